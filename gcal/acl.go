@@ -0,0 +1,475 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
+)
+
+// Roles that can be granted to the members of a channel on the channel calendar
+const (
+	ACLRoleFreeBusyReader = "freeBusyReader"
+	ACLRoleReader         = "reader"
+	ACLRoleWriter         = "writer"
+)
+
+const (
+	aclScopeTypeUser = "user"
+
+	// channelCalendarSyncInterval is how often channel calendars are shared again with the channel members,
+	// to catch up with members that connected their Google account after joining the channel
+	channelCalendarSyncInterval = time.Hour
+
+	channelMembersPerPage = 200
+)
+
+// ChannelCalendar links a calendar owned by a Mattermost user to a Mattermost channel. The calendar is
+// shared with the Google accounts connected by the channel members.
+type ChannelCalendar struct {
+	ChannelID             string `json:"channel_id"`
+	CalendarID            string `json:"calendar_id"`
+	OwnerMattermostUserID string `json:"owner_mattermost_user_id"`
+
+	// Role is the access role given to the channel members
+	Role string `json:"role"`
+
+	// SharedWith are the Google accounts the calendar was shared with by the plugin. Accounts the
+	// calendar was shared with by other means are never revoked.
+	SharedWith []string `json:"shared_with"`
+
+	LastSyncAt int64 `json:"last_sync_at"`
+
+	Backoff syncBackoff `json:"backoff"`
+}
+
+// ShareCalendar gives access to a calendar of the user to a Google account
+func (c *client) ShareCalendar(calendarID, email, role string) error {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return errors.Wrap(err, "gcal ShareCalendar, error creating service")
+	}
+
+	rule := &calendar.AclRule{
+		Role: role,
+		Scope: &calendar.AclRuleScope{
+			Type:  aclScopeTypeUser,
+			Value: email,
+		},
+	}
+
+	_, err = service.Acl.Insert(calendarID, rule).SendNotifications(false).Do()
+	if err != nil {
+		return errors.Wrap(err, "gcal ShareCalendar, error inserting rule")
+	}
+
+	return nil
+}
+
+// UnshareCalendar revokes the access of a Google account to a calendar of the user
+func (c *client) UnshareCalendar(calendarID, email string) error {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return errors.Wrap(err, "gcal UnshareCalendar, error creating service")
+	}
+
+	err = service.Acl.Delete(calendarID, aclScopeTypeUser+":"+email).Do()
+	if err != nil && !isNotFoundError(err) {
+		return errors.Wrap(err, "gcal UnshareCalendar, error deleting rule")
+	}
+
+	return nil
+}
+
+// GetCalendarACL returns the role of every Google account a calendar of the user is shared with, by email
+func (c *client) GetCalendarACL(calendarID string) (map[string]string, error) {
+	ctx := context.Background()
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetCalendarACL, error creating service")
+	}
+
+	roles := map[string]string{}
+	err = service.Acl.List(calendarID).Pages(ctx, func(page *calendar.Acl) error {
+		for _, rule := range page.Items {
+			if rule.Scope != nil && rule.Scope.Type == aclScopeTypeUser {
+				roles[rule.Scope.Value] = rule.Role
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetCalendarACL, error listing rules")
+	}
+
+	return roles, nil
+}
+
+// LinkChannelCalendar links a calendar of the user to a channel, sharing it with the channel members with the given role
+func (c *client) LinkChannelCalendar(channelID, calendarID, role string) error {
+	if role == "" {
+		role = ACLRoleReader
+	}
+	if role != ACLRoleFreeBusyReader && role != ACLRoleReader && role != ACLRoleWriter {
+		return errors.Errorf("gcal LinkChannelCalendar, invalid role %s", role)
+	}
+
+	cc := &ChannelCalendar{}
+	found, err := kvGet(channelCalendarKeyPrefix+channelID, cc)
+	if err != nil {
+		return errors.Wrap(err, "gcal LinkChannelCalendar, error loading channel calendar")
+	}
+	if found && cc.CalendarID != calendarID {
+		return errors.Errorf("gcal LinkChannelCalendar, channel %s is already linked to another calendar", channelID)
+	}
+
+	cc.ChannelID = channelID
+	cc.CalendarID = calendarID
+	cc.OwnerMattermostUserID = c.mattermostUserID
+	cc.Role = role
+
+	err = kvSet(channelCalendarKeyPrefix+channelID, cc)
+	if err != nil {
+		return errors.Wrap(err, "gcal LinkChannelCalendar, error storing channel calendar")
+	}
+
+	err = kvSet(calendarChannelKeyPrefix+calendarID, channelID)
+	if err != nil {
+		return errors.Wrap(err, "gcal LinkChannelCalendar, error storing calendar channel")
+	}
+
+	owned := []string{}
+	_, err = kvGet(ownedChannelCalendarsKeyPrefix+c.mattermostUserID, &owned)
+	if err != nil {
+		return errors.Wrap(err, "gcal LinkChannelCalendar, error loading owned channel calendars")
+	}
+	if !slices.Contains(owned, channelID) {
+		err = kvSet(ownedChannelCalendarsKeyPrefix+c.mattermostUserID, append(owned, channelID))
+		if err != nil {
+			return errors.Wrap(err, "gcal LinkChannelCalendar, error storing owned channel calendars")
+		}
+	}
+
+	return c.syncChannelCalendar(cc)
+}
+
+// UnlinkChannelCalendar removes the link between a channel and its calendar, revoking the access given to the channel members
+func (c *client) UnlinkChannelCalendar(channelID string) error {
+	cc := &ChannelCalendar{}
+	found, err := kvGet(channelCalendarKeyPrefix+channelID, cc)
+	if err != nil {
+		return errors.Wrap(err, "gcal UnlinkChannelCalendar, error loading channel calendar")
+	}
+	if !found {
+		return nil
+	}
+	if cc.OwnerMattermostUserID != c.mattermostUserID {
+		return errors.New("gcal UnlinkChannelCalendar, only the owner of the calendar can unlink it")
+	}
+
+	for _, email := range cc.SharedWith {
+		err = c.UnshareCalendar(cc.CalendarID, email)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = deleteChannelCalendarLink(cc)
+	if err != nil {
+		return errors.Wrap(err, "gcal UnlinkChannelCalendar")
+	}
+
+	return nil
+}
+
+// loadChannelCalendar returns the calendar linked to a channel, or nil if the channel has none
+func loadChannelCalendar(channelID string) (*ChannelCalendar, error) {
+	cc := &ChannelCalendar{}
+	found, err := kvGet(channelCalendarKeyPrefix+channelID, cc)
+	if err != nil || !found {
+		return nil, err
+	}
+	return cc, nil
+}
+
+// deleteChannelCalendarLink forgets the link between a channel and its calendar
+func deleteChannelCalendarLink(cc *ChannelCalendar) error {
+	owned := []string{}
+	_, err := kvGet(ownedChannelCalendarsKeyPrefix+cc.OwnerMattermostUserID, &owned)
+	if err != nil {
+		return errors.Wrap(err, "error loading owned channel calendars")
+	}
+	owned = slices.DeleteFunc(owned, func(id string) bool { return id == cc.ChannelID })
+	err = kvSet(ownedChannelCalendarsKeyPrefix+cc.OwnerMattermostUserID, owned)
+	if err != nil {
+		return errors.Wrap(err, "error storing owned channel calendars")
+	}
+
+	_ = kvDelete(channelCalendarNeedsSyncKeyPrefix + cc.ChannelID)
+	_ = kvDelete(calendarChannelKeyPrefix + cc.CalendarID)
+	return kvDelete(channelCalendarKeyPrefix + cc.ChannelID)
+}
+
+// MarkChannelCalendarForSync flags the calendar of a channel, if any, to be shared again with the channel members,
+// or deleted if the channel was archived. Only the owner of the calendar can do it, so it happens the next time
+// the plugin acts on behalf of the owner.
+func MarkChannelCalendarForSync(channelID string) error {
+	cc := &ChannelCalendar{}
+	found, err := kvGet(channelCalendarKeyPrefix+channelID, cc)
+	if err != nil || !found {
+		return err
+	}
+
+	return kvSet(channelCalendarNeedsSyncKeyPrefix+channelID, true)
+}
+
+// loadOwnedChannelCalendars returns the channel calendars owned by the user
+func loadOwnedChannelCalendars(mattermostUserID string) ([]*ChannelCalendar, error) {
+	owned := []string{}
+	_, err := kvGet(ownedChannelCalendarsKeyPrefix+mattermostUserID, &owned)
+	if err != nil {
+		return nil, err
+	}
+
+	calendars := []*ChannelCalendar{}
+	for _, channelID := range owned {
+		cc, err := loadChannelCalendar(channelID)
+		if err != nil || cc == nil {
+			continue
+		}
+		calendars = append(calendars, cc)
+	}
+	return calendars, nil
+}
+
+// isChannelCalendarSyncDue returns true if the channel calendar is flagged or due for a sync, and not backing off
+func isChannelCalendarSyncDue(cc *ChannelCalendar, now time.Time) (bool, error) {
+	if cc.Backoff.waiting(now) {
+		return false, nil
+	}
+
+	needsSync := false
+	_, err := kvGet(channelCalendarNeedsSyncKeyPrefix+cc.ChannelID, &needsSync)
+	if err != nil {
+		return false, err
+	}
+	return needsSync || now.Sub(time.UnixMilli(cc.LastSyncAt)) >= channelCalendarSyncInterval, nil
+}
+
+// isChannelCalendarsSyncDue returns true if a channel calendar owned by the user is due for a sync
+func isChannelCalendarsSyncDue(mattermostUserID string, now time.Time) (bool, error) {
+	calendars, err := loadOwnedChannelCalendars(mattermostUserID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, cc := range calendars {
+		due, err := isChannelCalendarSyncDue(cc, now)
+		if err == nil && due {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// syncOwnedChannelCalendars shares the channel calendars owned by the user that are flagged or due for a sync.
+// Calendars failing to sync are retried with a backoff.
+func (c *client) syncOwnedChannelCalendars() {
+	if pluginAPI == nil || c.mattermostUserID == "" {
+		return
+	}
+
+	calendars, err := loadOwnedChannelCalendars(c.mattermostUserID)
+	if err != nil {
+		c.Logger.Warnf("gcal: error loading owned channel calendars. err=%v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, cc := range calendars {
+		due, err := isChannelCalendarSyncDue(cc, now)
+		if err != nil || !due {
+			continue
+		}
+
+		archived, err := isChannelArchived(cc.ChannelID)
+		if err != nil {
+			continue
+		}
+		if archived {
+			// The calendar belongs to the channel, so it goes away with it
+			err = c.DeleteCalendar(cc.CalendarID)
+			if err != nil {
+				c.Logger.With(bot.LogContext{
+					"channelID":  cc.ChannelID,
+					"calendarID": cc.CalendarID,
+				}).Warnf("gcal: error deleting the calendar of an archived channel. err=%v", err)
+				c.backOffChannelCalendarSync(cc, now)
+			}
+			continue
+		}
+
+		err = c.syncChannelCalendar(cc)
+		if isNotFoundError(err) {
+			// The calendar was deleted from Google
+			err = deleteChannelCalendarLink(cc)
+		}
+		if err != nil {
+			c.Logger.With(bot.LogContext{
+				"channelID":  cc.ChannelID,
+				"calendarID": cc.CalendarID,
+			}).Warnf("gcal: error sharing channel calendar. err=%v", err)
+			c.backOffChannelCalendarSync(cc, now)
+		}
+	}
+}
+
+// backOffChannelCalendarSync delays the next sync of a channel calendar that failed to sync
+func (c *client) backOffChannelCalendarSync(cc *ChannelCalendar, now time.Time) {
+	cc.Backoff.failed(now)
+	err := kvSet(channelCalendarKeyPrefix+cc.ChannelID, cc)
+	if err != nil {
+		c.Logger.Warnf("gcal: error storing channel calendar. err=%v", err)
+	}
+}
+
+// isChannelArchived returns true if the channel was archived
+func isChannelArchived(channelID string) (bool, error) {
+	channel, appErr := pluginAPI.GetChannel(channelID)
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "error getting channel")
+	}
+	return channel.DeleteAt > 0, nil
+}
+
+// syncChannelCalendar shares the calendar with the channel members, and revokes the access of former members
+func (c *client) syncChannelCalendar(cc *ChannelCalendar) error {
+	// Cleared before syncing so memberships changing in the meantime trigger another sync
+	err := kvDelete(channelCalendarNeedsSyncKeyPrefix + cc.ChannelID)
+	if err != nil {
+		return err
+	}
+
+	members, err := getChannelMembersGoogleEmails(cc.ChannelID, cc.OwnerMattermostUserID)
+	if err != nil {
+		return err
+	}
+
+	existing, err := c.GetCalendarACL(cc.CalendarID)
+	if err != nil {
+		return err
+	}
+
+	grant, revoke, sharedWith := diffChannelCalendarACL(members, cc.SharedWith, existing, cc.Role)
+
+	var syncErr error
+	for _, email := range grant {
+		if err = c.ShareCalendar(cc.CalendarID, email, cc.Role); err != nil {
+			syncErr = err
+			if !slices.Contains(cc.SharedWith, email) {
+				sharedWith = slices.DeleteFunc(sharedWith, func(e string) bool { return e == email })
+			}
+		}
+	}
+	for _, email := range revoke {
+		if err = c.UnshareCalendar(cc.CalendarID, email); err != nil {
+			syncErr = err
+			sharedWith = append(sharedWith, email)
+		}
+	}
+
+	cc.SharedWith = sharedWith
+	cc.LastSyncAt = time.Now().UnixMilli()
+	if syncErr == nil {
+		cc.Backoff.succeeded()
+	}
+	err = kvSet(channelCalendarKeyPrefix+cc.ChannelID, cc)
+	if err != nil {
+		return err
+	}
+
+	if syncErr != nil {
+		_ = kvSet(channelCalendarNeedsSyncKeyPrefix+cc.ChannelID, true)
+		return syncErr
+	}
+
+	c.Logger.With(bot.LogContext{
+		"channelID":  cc.ChannelID,
+		"calendarID": cc.CalendarID,
+		"granted":    len(grant),
+		"revoked":    len(revoke),
+	}).Debugf("gcal: synced channel calendar.")
+
+	return nil
+}
+
+// getChannelMembersGoogleEmails returns the Google accounts connected by the members of a channel, skipping the given user
+func getChannelMembersGoogleEmails(channelID, skipMattermostUserID string) ([]string, error) {
+	if pluginAPI == nil {
+		return nil, errPluginAPINotAvailable
+	}
+
+	emails := []string{}
+	for page := 0; ; page++ {
+		members, appErr := pluginAPI.GetChannelMembers(channelID, page, channelMembersPerPage)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "error getting channel members")
+		}
+
+		for _, member := range members {
+			if member.UserId == skipMattermostUserID {
+				continue
+			}
+
+			email, err := loadGoogleEmail(member.UserId)
+			if err != nil {
+				return nil, err
+			}
+			if email != "" {
+				emails = append(emails, email)
+			}
+		}
+
+		if len(members) < channelMembersPerPage {
+			return emails, nil
+		}
+	}
+}
+
+// diffChannelCalendarACL returns the accounts to share the calendar with and to revoke, given the accounts of the channel
+// members, the accounts previously shared by the plugin and the current calendar ACL. It also returns the accounts shared
+// by the plugin once the changes are applied. Accounts with access given by other means are left untouched.
+func diffChannelCalendarACL(members, sharedWith []string, existing map[string]string, role string) (grant, revoke, newSharedWith []string) {
+	grant = []string{}
+	revoke = []string{}
+	newSharedWith = []string{}
+
+	for _, email := range members {
+		existingRole, hasAccess := existing[email]
+		managed := slices.Contains(sharedWith, email)
+
+		switch {
+		case !hasAccess, managed && existingRole != role:
+			grant = append(grant, email)
+			newSharedWith = append(newSharedWith, email)
+		case managed:
+			newSharedWith = append(newSharedWith, email)
+		}
+	}
+
+	for _, email := range sharedWith {
+		if !slices.Contains(members, email) {
+			revoke = append(revoke, email)
+		}
+	}
+
+	return grant, revoke, newSharedWith
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffChannelCalendarACL(t *testing.T) {
+	for _, tc := range []struct {
+		Name               string
+		Members            []string
+		SharedWith         []string
+		Existing           map[string]string
+		Role               string
+		ExpectedGrant      []string
+		ExpectedRevoke     []string
+		ExpectedSharedWith []string
+	}{
+		{
+			Name:               "new members are granted access",
+			Members:            []string{"alice@example.com", "bob@example.com"},
+			SharedWith:         []string{"alice@example.com"},
+			Existing:           map[string]string{"alice@example.com": ACLRoleReader, "owner@example.com": AccessRoleOwner},
+			ExpectedGrant:      []string{"bob@example.com"},
+			ExpectedRevoke:     []string{},
+			ExpectedSharedWith: []string{"alice@example.com", "bob@example.com"},
+		},
+		{
+			Name:               "former members are revoked",
+			Members:            []string{"alice@example.com"},
+			SharedWith:         []string{"alice@example.com", "bob@example.com"},
+			Existing:           map[string]string{"alice@example.com": ACLRoleReader, "bob@example.com": ACLRoleReader},
+			ExpectedGrant:      []string{},
+			ExpectedRevoke:     []string{"bob@example.com"},
+			ExpectedSharedWith: []string{"alice@example.com"},
+		},
+		{
+			Name:               "access given by other means is left untouched",
+			Members:            []string{"alice@example.com"},
+			SharedWith:         []string{},
+			Existing:           map[string]string{"alice@example.com": ACLRoleWriter},
+			ExpectedGrant:      []string{},
+			ExpectedRevoke:     []string{},
+			ExpectedSharedWith: []string{},
+		},
+		{
+			Name:               "role changes are applied to shared accounts",
+			Members:            []string{"alice@example.com"},
+			SharedWith:         []string{"alice@example.com"},
+			Existing:           map[string]string{"alice@example.com": ACLRoleReader},
+			Role:               ACLRoleWriter,
+			ExpectedGrant:      []string{"alice@example.com"},
+			ExpectedRevoke:     []string{},
+			ExpectedSharedWith: []string{"alice@example.com"},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			role := tc.Role
+			if role == "" {
+				role = ACLRoleReader
+			}
+
+			grant, revoke, sharedWith := diffChannelCalendarACL(tc.Members, tc.SharedWith, tc.Existing, role)
+			require.Equal(t, tc.ExpectedGrant, grant)
+			require.Equal(t, tc.ExpectedRevoke, revoke)
+			require.Equal(t, tc.ExpectedSharedWith, sharedWith)
+		})
+	}
+}
+
+func TestDeleteChannelCalendarLink(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	owned, err := json.Marshal([]string{"channel_1", "channel_2"})
+	require.NoError(t, err)
+
+	api := &plugintest.API{}
+	api.On("KVGet", ownedChannelCalendarsKeyPrefix+"owner_id").Return(owned, nil)
+	api.On("KVSet", mock.Anything, mock.Anything).Return(nil)
+	api.On("KVDelete", mock.Anything).Return(nil)
+	SetPluginAPI(api)
+
+	err = deleteChannelCalendarLink(&ChannelCalendar{
+		ChannelID:             "channel_1",
+		CalendarID:            "calendar_1",
+		OwnerMattermostUserID: "owner_id",
+	})
+	require.NoError(t, err)
+
+	remaining, err := json.Marshal([]string{"channel_2"})
+	require.NoError(t, err)
+	api.AssertCalled(t, "KVSet", ownedChannelCalendarsKeyPrefix+"owner_id", remaining)
+	api.AssertCalled(t, "KVDelete", channelCalendarKeyPrefix+"channel_1")
+	api.AssertCalled(t, "KVDelete", calendarChannelKeyPrefix+"calendar_1")
+	api.AssertCalled(t, "KVDelete", channelCalendarNeedsSyncKeyPrefix+"channel_1")
+}
+
+func TestIsChannelArchived(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	api := &plugintest.API{}
+	api.On("GetChannel", "active").Return(&model.Channel{Id: "active"}, nil)
+	api.On("GetChannel", "archived").Return(&model.Channel{Id: "archived", DeleteAt: 1}, nil)
+	SetPluginAPI(api)
+
+	archived, err := isChannelArchived("active")
+	require.NoError(t, err)
+	require.False(t, archived)
+
+	archived, err = isChannelArchived("archived")
+	require.NoError(t, err)
+	require.True(t, archived)
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+const (
+	backgroundSyncBackoffKeyPrefix = "gcal_background_sync_backoff_"
+
+	// syncBackoffBase and syncBackoffMax bound the wait before retrying a periodic sync that failed
+	syncBackoffBase = 5 * time.Minute
+	syncBackoffMax  = 24 * time.Hour
+)
+
+var errClientNotConnected = errors.New("the client is not connected to Google")
+
+// syncBackoff delays the periodic syncs that keep failing, like the ones of a calendar the user lost access to
+type syncBackoff struct {
+	Failures int   `json:"failures"`
+	RetryAt  int64 `json:"retry_at"`
+}
+
+// waiting returns true if the sync must not be retried yet
+func (b *syncBackoff) waiting(now time.Time) bool {
+	return now.Before(time.UnixMilli(b.RetryAt))
+}
+
+// failed doubles the wait before the next attempt
+func (b *syncBackoff) failed(now time.Time) {
+	b.Failures++
+
+	wait := syncBackoffMax
+	if b.Failures <= 10 {
+		wait = min(syncBackoffBase<<(b.Failures-1), syncBackoffMax)
+	}
+	b.RetryAt = now.Add(wait).UnixMilli()
+}
+
+func (b *syncBackoff) succeeded() {
+	*b = syncBackoff{}
+}
+
+// syncSchedule is when a periodic sync of a user last ran, kept along with the state of the sync
+type syncSchedule struct {
+	LastCheckAt int64       `json:"last_check_at"`
+	Backoff     syncBackoff `json:"backoff"`
+}
+
+func (s *syncSchedule) isDue(now time.Time, interval time.Duration) bool {
+	return !s.Backoff.waiting(now) && now.Sub(time.UnixMilli(s.LastCheckAt)) >= interval
+}
+
+// periodicSync is a periodic work of the users they can turn off, like following their working location
+type periodicSync struct {
+	// name describes the sync in the logs
+	name              string
+	keyPrefix         string
+	disabledKeyPrefix string
+	interval          time.Duration
+}
+
+// setEnabled turns the sync on or off for the user
+func (ps *periodicSync) setEnabled(mattermostUserID string, enabled bool) error {
+	if enabled {
+		return kvDelete(ps.disabledKeyPrefix + mattermostUserID)
+	}
+	return kvSet(ps.disabledKeyPrefix+mattermostUserID, true)
+}
+
+func (ps *periodicSync) isEnabled(mattermostUserID string) (bool, error) {
+	disabled := false
+	_, err := kvGet(ps.disabledKeyPrefix+mattermostUserID, &disabled)
+	return !disabled, err
+}
+
+// isDue returns true if the sync is turned on for the user and due
+func (ps *periodicSync) isDue(mattermostUserID string, now time.Time) (bool, error) {
+	enabled, err := ps.isEnabled(mattermostUserID)
+	if err != nil || !enabled {
+		return false, err
+	}
+
+	// Only the schedule is read out of the state
+	schedule := &syncSchedule{}
+	_, err = kvGet(ps.keyPrefix+mattermostUserID, schedule)
+	if err != nil {
+		return false, err
+	}
+	return schedule.isDue(now, ps.interval), nil
+}
+
+// runPeriodicSync runs the sync of the user of the client with their stored state when it is due, and stores
+// the updated state. Only the backoff is stored when the sync fails, as the state may be half updated.
+func runPeriodicSync[S any, P interface {
+	*S
+	schedule() *syncSchedule
+}](c *client, ps *periodicSync, sync func(state P) error) {
+	if pluginAPI == nil || c.mattermostUserID == "" {
+		return
+	}
+
+	enabled, err := ps.isEnabled(c.mattermostUserID)
+	if err != nil || !enabled {
+		return
+	}
+
+	key := ps.keyPrefix + c.mattermostUserID
+	state := P(new(S))
+	_, err = kvGet(key, state)
+	if err != nil {
+		c.Logger.Warnf("gcal: error loading %s. err=%v", ps.name, err)
+		return
+	}
+	now := time.Now()
+	if !state.schedule().isDue(now, ps.interval) {
+		return
+	}
+
+	original := *state
+	err = sync(state)
+	if err != nil {
+		c.Logger.Warnf("gcal: error syncing %s. err=%v", ps.name, err)
+		state = &original
+		state.schedule().Backoff.failed(now)
+	} else {
+		state.schedule().LastCheckAt = now.UnixMilli()
+		state.schedule().Backoff.succeeded()
+	}
+
+	err = kvSet(key, state)
+	if err != nil {
+		c.Logger.Warnf("gcal: error storing %s. err=%v", ps.name, err)
+	}
+}
+
+// IsBackgroundSyncDue returns true if some periodic work of the user is due, so their client is only made when needed
+func IsBackgroundSyncDue(mattermostUserID string, now time.Time) (bool, error) {
+	backoff := &syncBackoff{}
+	_, err := kvGet(backgroundSyncBackoffKeyPrefix+mattermostUserID, backoff)
+	if err != nil {
+		return false, err
+	}
+	if backoff.waiting(now) {
+		return false, nil
+	}
+
+	for _, isDue := range []func(string, time.Time) (bool, error){
+		isChannelCalendarsSyncDue,
+		workingLocationStatusSync.isDue,
+		autoResponderSync.isDue,
+	} {
+		due, err := isDue(mattermostUserID, now)
+		if err != nil || due {
+			return due, err
+		}
+	}
+
+	return false, nil
+}
+
+// SyncBackground runs the periodic work of the user of the client: sharing the calendars of the channels they own,
+// and following their working location and out of office events
+func SyncBackground(remoteClient remote.Client) error {
+	c, ok := remoteClient.(*client)
+	if !ok || c.mattermostUserID == "" {
+		return errClientNotConnected
+	}
+
+	c.syncOwnedChannelCalendars()
+	c.syncWorkingLocationStatus()
+	c.syncOutOfOfficeAutoResponder()
+
+	return kvDelete(backgroundSyncBackoffKeyPrefix + c.mattermostUserID)
+}
+
+// DelayBackgroundSync backs off the periodic work of a user whose client couldn't be made
+func DelayBackgroundSync(mattermostUserID string) error {
+	backoff := &syncBackoff{}
+	_, err := kvGet(backgroundSyncBackoffKeyPrefix+mattermostUserID, backoff)
+	if err != nil {
+		return err
+	}
+
+	backoff.failed(time.Now())
+	return kvSet(backgroundSyncBackoffKeyPrefix+mattermostUserID, backoff)
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncBackoff(t *testing.T) {
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	backoff := &syncBackoff{}
+	require.False(t, backoff.waiting(now))
+
+	for _, expectedWait := range []time.Duration{
+		5 * time.Minute,
+		10 * time.Minute,
+		20 * time.Minute,
+	} {
+		backoff.failed(now)
+		require.True(t, backoff.waiting(now.Add(expectedWait-time.Second)))
+		require.False(t, backoff.waiting(now.Add(expectedWait)))
+	}
+
+	for range 20 {
+		backoff.failed(now)
+	}
+	require.Equal(t, now.Add(syncBackoffMax).UnixMilli(), backoff.RetryAt)
+
+	backoff.succeeded()
+	require.False(t, backoff.waiting(now))
+	require.Zero(t, backoff.Failures)
+}
+
+func TestIsBackgroundSyncDue(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	recent, err := json.Marshal(&autoResponder{syncSchedule: syncSchedule{LastCheckAt: now.Add(-time.Minute).UnixMilli()}})
+	require.NoError(t, err)
+	backingOff, err := json.Marshal(&syncBackoff{Failures: 1, RetryAt: now.Add(time.Minute).UnixMilli()})
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		kv          map[string][]byte
+		expectedDue bool
+	}{
+		"never synced": {
+			kv: map[string][]byte{
+				ownedChannelCalendarsKeyPrefix + "user": []byte(`["channel"]`),
+				channelCalendarKeyPrefix + "channel":    []byte(`{"channel_id": "channel"}`),
+			},
+			expectedDue: true,
+		},
+		"synced recently": {
+			kv: map[string][]byte{
+				workingLocationStatusDisabledKeyPrefix + "user": []byte("true"),
+				autoResponderKeyPrefix + "user":                 recent,
+			},
+			expectedDue: false,
+		},
+		"backing off": {
+			kv: map[string][]byte{
+				backgroundSyncBackoffKeyPrefix + "user": backingOff,
+			},
+			expectedDue: false,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("KVGet", mock.AnythingOfType("string")).Return(func(key string) []byte { return tc.kv[key] }, nil)
+			SetPluginAPI(api)
+
+			due, err := IsBackgroundSyncDue("user", now)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedDue, due)
+		})
+	}
+}
@@ -5,27 +5,268 @@ package gcal
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
 
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
 )
 
-// CreateCalendar creates a calendar
+// channelCalendarDescription is the description of the calendars created for a Mattermost channel
+const channelCalendarDescription = "Calendar of the %s channel in Mattermost: %s"
+
+const (
+	selectedCalendarsKeyPrefix = "gcal_selected_calendars_"
+
+	// selectedCalendarsMaxAge is how long the selected calendars of a user are used before reading their calendar
+	// list again
+	selectedCalendarsMaxAge = 15 * time.Minute
+)
+
+// googleProvidedCalendarSuffix is the ID suffix of the calendars provided by Google, like holidays or contact birthdays
+const googleProvidedCalendarSuffix = "@group.v.calendar.google.com"
+
+// Access roles a user can have on a calendar of their calendar list
+const (
+	AccessRoleOwner          = "owner"
+	AccessRoleWriter         = "writer"
+	AccessRoleReader         = "reader"
+	AccessRoleFreeBusyReader = "freeBusyReader"
+)
+
+// CalendarInfo is a calendar of the user's calendar list. remote.Calendar has no room for
+// the Google specific metadata, so it is kept alongside it.
+type CalendarInfo struct {
+	*remote.Calendar
+
+	// AccessRole is the effective access role of the user on the calendar
+	AccessRole      string
+	BackgroundColor string
+	ForegroundColor string
+	TimeZone        string
+
+	// Primary is set for the user's own primary calendar
+	Primary bool
+
+	// Hidden is set when the calendar is hidden from the Google Calendar list
+	Hidden bool
+
+	// Selected is set when the calendar content is shown in the Google Calendar UI
+	Selected bool
+}
+
+// selectedCalendars are the calendars whose events are shown to a user, as last read from their calendar list
+type selectedCalendars struct {
+	CalendarIDs []string `json:"calendar_ids"`
+	ReadAt      int64    `json:"read_at"`
+}
+
+// CanWrite returns true if the user is allowed to create and modify events on the calendar
+func (ci *CalendarInfo) CanWrite() bool {
+	return ci.AccessRole == AccessRoleOwner || ci.AccessRole == AccessRoleWriter
+}
+
+// CreateCalendar creates a secondary calendar owned by the user
 func (c *client) CreateCalendar(calIn *remote.Calendar) (*remote.Calendar, error) {
-	return nil, errors.New("gcal CreateCalendar not implemented")
+	return c.createCalendar(&calendar.Calendar{
+		Summary: calIn.Name,
+	})
+}
+
+// CreateChannelCalendar creates a secondary calendar owned by the user that belongs to a Mattermost channel,
+// and shares it with the channel members with the given role
+func (c *client) CreateChannelCalendar(channelID, channelDisplayName, channelURL, role string) (*remote.Calendar, error) {
+	cal, err := c.createCalendar(&calendar.Calendar{
+		Summary:     channelDisplayName,
+		Description: fmt.Sprintf(channelCalendarDescription, channelDisplayName, channelURL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.LinkChannelCalendar(channelID, cal.ID, role)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal CreateChannelCalendar, error linking calendar to channel")
+	}
+
+	return cal, nil
+}
+
+func (c *client) createCalendar(in *calendar.Calendar) (*remote.Calendar, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal CreateCalendar, error creating service")
+	}
+
+	googleCal, err := service.Calendars.Insert(in).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal CreateCalendar, error creating calendar")
+	}
+
+	c.expireSelectedCalendars()
+
+	c.Logger.With(bot.LogContext{
+		"calendarID": googleCal.Id,
+	}).Debugf("gcal: created calendar.")
+
+	return convertGoogleCalendarToRemoteCalendar(googleCal), nil
 }
 
-// DeleteCalendar deletes a calendar
+// DeleteCalendar deletes a secondary calendar owned by the user, unlinking it from its channel if it belongs to one.
+// Deleting a calendar that no longer exists is not an error.
 func (c *client) DeleteCalendar(calID string) error {
-	return errors.New("gcal DeleteCalendar not implemented")
+	if calID == defaultCalendarName {
+		return errors.New("gcal DeleteCalendar, the primary calendar can't be deleted")
+	}
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return errors.Wrap(err, "gcal DeleteCalendar, error creating service")
+	}
+
+	err = service.Calendars.Delete(calID).Do()
+	if err != nil && !isNotFoundError(err) {
+		return errors.Wrap(err, "gcal DeleteCalendar, error deleting calendar")
+	}
+	c.expireSelectedCalendars()
+
+	channelID, err := loadCalendarChannelID(calID)
+	if err != nil && err != errPluginAPINotAvailable {
+		return errors.Wrap(err, "gcal DeleteCalendar, error loading calendar channel")
+	}
+	if channelID != "" {
+		cc, err := loadChannelCalendar(channelID)
+		if err != nil {
+			return errors.Wrap(err, "gcal DeleteCalendar, error loading channel calendar")
+		}
+		if cc != nil {
+			err = deleteChannelCalendarLink(cc)
+			if err != nil {
+				return errors.Wrap(err, "gcal DeleteCalendar, error unlinking channel")
+			}
+		}
+	}
+
+	c.Logger.With(bot.LogContext{
+		"calendarID": calID,
+	}).Debugf("gcal: deleted calendar.")
+
+	return nil
 }
 
-// GetCalendars returns a list of calendars
+// GetCalendars returns every calendar present in the user's calendar list
 func (c *client) GetCalendars(remoteUserID string) ([]*remote.Calendar, error) {
-	return nil, errors.New("gcal GetCalendars not implemented")
+	infos, err := c.GetCalendarList()
+	if err != nil {
+		return nil, err
+	}
+
+	calendars := make([]*remote.Calendar, 0, len(infos))
+	for _, info := range infos {
+		calendars = append(calendars, info.Calendar)
+	}
+
+	return calendars, nil
+}
+
+// GetCalendarList returns every calendar present in the user's calendar list, including hidden ones,
+// along with the metadata that is not part of remote.Calendar
+func (c *client) GetCalendarList() ([]*CalendarInfo, error) {
+	ctx := context.Background()
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetCalendarList, error creating service")
+	}
+
+	infos := []*CalendarInfo{}
+	err = service.CalendarList.
+		List().
+		ShowHidden(true).
+		ShowDeleted(false).
+		Pages(ctx, func(page *calendar.CalendarList) error {
+			for _, entry := range page.Items {
+				infos = append(infos, convertCalendarListEntryToCalendarInfo(entry))
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetCalendarList, error listing calendars")
+	}
+
+	return infos, nil
+}
+
+// getSelectedCalendarIDs returns the IDs of the calendars whose events are shown to the user, which are the
+// ones selected in the Google Calendar UI. The primary calendar is always included and comes first. The calendar
+// list is only read again once the stored selection is too old.
+func (c *client) getSelectedCalendarIDs() ([]string, error) {
+	stored := pluginAPI != nil && c.mattermostUserID != ""
+	key := selectedCalendarsKeyPrefix + c.mattermostUserID
+
+	if stored {
+		selected := &selectedCalendars{}
+		found, err := kvGet(key, selected)
+		if err != nil {
+			c.Logger.Warnf("gcal: error loading selected calendars. err=%v", err)
+		}
+		if found && time.Since(time.UnixMilli(selected.ReadAt)) < selectedCalendarsMaxAge {
+			return selected.CalendarIDs, nil
+		}
+	}
+
+	infos, err := c.GetCalendarList()
+	if err != nil {
+		return nil, err
+	}
+	calendarIDs := selectedCalendarIDs(infos)
+
+	if stored {
+		err = kvSet(key, &selectedCalendars{
+			CalendarIDs: calendarIDs,
+			ReadAt:      time.Now().UnixMilli(),
+		})
+		if err != nil {
+			c.Logger.Warnf("gcal: error storing selected calendars. err=%v", err)
+		}
+	}
+
+	return calendarIDs, nil
+}
+
+// expireSelectedCalendars makes the next read of the selected calendars read the calendar list of the user again,
+// like after a calendar was added or removed through the plugin
+func (c *client) expireSelectedCalendars() {
+	if pluginAPI == nil || c.mattermostUserID == "" {
+		return
+	}
+
+	err := kvDelete(selectedCalendarsKeyPrefix + c.mattermostUserID)
+	if err != nil {
+		c.Logger.Warnf("gcal: error expiring selected calendars. err=%v", err)
+	}
+}
+
+func selectedCalendarIDs(infos []*CalendarInfo) []string {
+	calendarIDs := []string{defaultCalendarName}
+	for _, info := range infos {
+		if info.Primary || info.Hidden || !info.Selected {
+			continue
+		}
+
+		// Event details are not available for free/busy calendars, and Google provided calendars are not meetings
+		if info.AccessRole == AccessRoleFreeBusyReader || strings.HasSuffix(info.ID, googleProvidedCalendarSuffix) {
+			continue
+		}
+
+		calendarIDs = append(calendarIDs, info.ID)
+	}
+
+	return calendarIDs
 }
 
 // GetDefaultCalendar returns the default calendar for the user
@@ -46,6 +287,31 @@ func (c *client) GetDefaultCalendar() (*remote.Calendar, error) {
 	return remoteCal, nil
 }
 
+// convertCalendarListEntryToCalendarInfo converts an entry of the user's calendar list to a local representation calendar
+func convertCalendarListEntryToCalendarInfo(entry *calendar.CalendarListEntry) *CalendarInfo {
+	// The override is the name the user chose for a calendar they don't own
+	name := entry.Summary
+	if entry.SummaryOverride != "" {
+		name = entry.SummaryOverride
+	}
+
+	return &CalendarInfo{
+		Calendar: &remote.Calendar{
+			ID:           entry.Id,
+			Name:         name,
+			Events:       []remote.Event{},
+			CalendarView: []remote.Event{},
+		},
+		AccessRole:      entry.AccessRole,
+		BackgroundColor: entry.BackgroundColor,
+		ForegroundColor: entry.ForegroundColor,
+		TimeZone:        entry.TimeZone,
+		Primary:         entry.Primary,
+		Hidden:          entry.Hidden,
+		Selected:        entry.Selected,
+	}
+}
+
 // convertGoogleCalendarToRemoteCalendar converts a google calendar to a local representation calendar
 func convertGoogleCalendarToRemoteCalendar(cal *calendar.Calendar) *remote.Calendar {
 	return &remote.Calendar{
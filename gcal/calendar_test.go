@@ -0,0 +1,151 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestConvertCalendarListEntryToCalendarInfo(t *testing.T) {
+	for _, tc := range []struct {
+		Name  string
+		In    *calendar.CalendarListEntry
+		Check func(t *testing.T, info *CalendarInfo)
+	}{
+		{
+			Name: "primary calendar metadata is converted",
+			In: &calendar.CalendarListEntry{
+				Id:              "gcal-plugin@mattermost.com",
+				Summary:         "gcal-plugin@mattermost.com",
+				AccessRole:      AccessRoleOwner,
+				BackgroundColor: "#9fe1e7",
+				ForegroundColor: "#000000",
+				TimeZone:        "Europe/Madrid",
+				Primary:         true,
+				Selected:        true,
+			},
+			Check: func(t *testing.T, info *CalendarInfo) {
+				require.Equal(t, "gcal-plugin@mattermost.com", info.ID)
+				require.Equal(t, "gcal-plugin@mattermost.com", info.Name)
+				require.Equal(t, AccessRoleOwner, info.AccessRole)
+				require.Equal(t, "#9fe1e7", info.BackgroundColor)
+				require.Equal(t, "#000000", info.ForegroundColor)
+				require.Equal(t, "Europe/Madrid", info.TimeZone)
+				require.True(t, info.Primary)
+				require.True(t, info.Selected)
+				require.False(t, info.Hidden)
+				require.True(t, info.CanWrite())
+			},
+		},
+		{
+			Name: "summary override is used as name",
+			In: &calendar.CalendarListEntry{
+				Id:              "team@group.calendar.google.com",
+				Summary:         "Team",
+				SummaryOverride: "On-call",
+				AccessRole:      AccessRoleReader,
+				Hidden:          true,
+			},
+			Check: func(t *testing.T, info *CalendarInfo) {
+				require.Equal(t, "On-call", info.Name)
+				require.True(t, info.Hidden)
+				require.False(t, info.Primary)
+				require.False(t, info.CanWrite())
+			},
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			tc.Check(t, convertCalendarListEntryToCalendarInfo(tc.In))
+		})
+	}
+}
+
+func TestSelectedCalendarIDs(t *testing.T) {
+	infos := []*CalendarInfo{
+		convertCalendarListEntryToCalendarInfo(&calendar.CalendarListEntry{Id: "me@mattermost.com", AccessRole: AccessRoleOwner, Primary: true, Selected: true}),
+		convertCalendarListEntryToCalendarInfo(&calendar.CalendarListEntry{Id: "oncall@group.calendar.google.com", AccessRole: AccessRoleWriter, Selected: true}),
+		convertCalendarListEntryToCalendarInfo(&calendar.CalendarListEntry{Id: "unselected@group.calendar.google.com", AccessRole: AccessRoleReader}),
+		convertCalendarListEntryToCalendarInfo(&calendar.CalendarListEntry{Id: "hidden@group.calendar.google.com", AccessRole: AccessRoleReader, Selected: true, Hidden: true}),
+		convertCalendarListEntryToCalendarInfo(&calendar.CalendarListEntry{Id: "boss@mattermost.com", AccessRole: AccessRoleFreeBusyReader, Selected: true}),
+		convertCalendarListEntryToCalendarInfo(&calendar.CalendarListEntry{Id: "en.usa#holiday@group.v.calendar.google.com", AccessRole: AccessRoleReader, Selected: true}),
+	}
+
+	require.Equal(t, []string{defaultCalendarName, "oncall@group.calendar.google.com"}, selectedCalendarIDs(infos))
+}
+
+func TestGetSelectedCalendarIDs(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		require.Equal(t, "/calendar/v3/users/me/calendarList", r.URL.Path)
+		_, _ = w.Write([]byte(`{"items": [
+			{"id": "me@mattermost.com", "accessRole": "owner", "primary": true, "selected": true},
+			{"id": "oncall@group.calendar.google.com", "accessRole": "writer", "selected": true}
+		]}`))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	c := &client{
+		httpClient:       &http.Client{Transport: &redirectTransport{url: serverURL}},
+		mattermostUserID: "user",
+	}
+	listed := []string{defaultCalendarName, "oncall@group.calendar.google.com"}
+
+	for name, tc := range map[string]struct {
+		stored           *selectedCalendars
+		expected         []string
+		expectedRequests int
+	}{
+		"never read": {
+			expected:         listed,
+			expectedRequests: 1,
+		},
+		"recently read": {
+			stored:   &selectedCalendars{CalendarIDs: []string{defaultCalendarName}, ReadAt: time.Now().Add(-time.Minute).UnixMilli()},
+			expected: []string{defaultCalendarName},
+		},
+		"read too long ago": {
+			stored:           &selectedCalendars{CalendarIDs: []string{defaultCalendarName}, ReadAt: time.Now().Add(-time.Hour).UnixMilli()},
+			expected:         listed,
+			expectedRequests: 1,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			requests = 0
+			var data []byte
+			if tc.stored != nil {
+				data, err = json.Marshal(tc.stored)
+				require.NoError(t, err)
+			}
+			api := &plugintest.API{}
+			api.On("KVGet", selectedCalendarsKeyPrefix+"user").Return(data, nil)
+			api.On("KVSet", selectedCalendarsKeyPrefix+"user", mock.Anything).Return(nil)
+			SetPluginAPI(api)
+
+			calendarIDs, err := c.getSelectedCalendarIDs()
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, calendarIDs)
+			require.Equal(t, tc.expectedRequests, requests)
+			if tc.expectedRequests > 0 {
+				api.AssertCalled(t, "KVSet", selectedCalendarsKeyPrefix+"user", mock.Anything)
+			} else {
+				api.AssertNotCalled(t, "KVSet", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
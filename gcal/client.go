@@ -24,6 +24,9 @@ type client struct {
 
 	httpClient *http.Client
 
+	// mattermostUserID is the Mattermost user the client acts on behalf of
+	mattermostUserID string
+
 	conf *config.Config
 	bot.Logger
 }
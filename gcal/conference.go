@@ -0,0 +1,253 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
+)
+
+const (
+	// conferenceDataVersion is the version of the conference data supported when creating or changing events
+	conferenceDataVersion = 1
+
+	conferenceSolutionGoogleMeet = "hangoutsMeet"
+
+	conferenceStatusPending = "pending"
+	conferenceStatusSuccess = "success"
+
+	// conferenceWaitAttempts and conferenceWaitInterval bound the wait for Google to create a conference
+	conferenceWaitAttempts = 5
+	conferenceWaitInterval = 500 * time.Millisecond
+)
+
+// Types of the entry points of a conference
+const (
+	EntryPointTypeVideo = "video"
+	EntryPointTypePhone = "phone"
+	EntryPointTypeSIP   = "sip"
+	EntryPointTypeMore  = "more"
+)
+
+// hangoutLinkApplication is the application of the conferences only known through the legacy hangout link
+const hangoutLinkApplication = "Google Meet"
+
+// ConferenceEntryPoint is one of the ways to join a conference, like a video link or a dial-in number
+type ConferenceEntryPoint struct {
+	Type     string
+	Label    string
+	URI      string
+	Passcode string
+	Pin      string
+}
+
+// Conference is the conference of an event along with all of its entry points.
+// remote.Conference only keeps the video link.
+type Conference struct {
+	Application string
+	EntryPoints []ConferenceEntryPoint
+}
+
+// VideoURL returns the URL of the video entry point, or the first entry point if none is a video one
+func (c *Conference) VideoURL() string {
+	if entryPoint := c.entryPoint(EntryPointTypeVideo); entryPoint != nil {
+		return entryPoint.URI
+	}
+	if len(c.EntryPoints) > 0 {
+		return c.EntryPoints[0].URI
+	}
+	return ""
+}
+
+// DialIn returns the first phone entry point of the conference, or nil if it can't be joined by phone
+func (c *Conference) DialIn() *ConferenceEntryPoint {
+	return c.entryPoint(EntryPointTypePhone)
+}
+
+// dialInDetails returns the number and PIN to join the conference by phone, or an empty string if it can't be
+// joined by phone
+func (c *Conference) dialInDetails() string {
+	dialIn := c.DialIn()
+	if dialIn == nil {
+		return ""
+	}
+
+	number := dialIn.Label
+	if number == "" {
+		number = strings.TrimPrefix(dialIn.URI, "tel:")
+	}
+
+	details := "Dial-in: " + number
+	if dialIn.Pin != "" {
+		details += fmt.Sprintf(" (PIN: %s)", dialIn.Pin)
+	} else if dialIn.Passcode != "" {
+		details += fmt.Sprintf(" (passcode: %s)", dialIn.Passcode)
+	}
+	return details
+}
+
+func (c *Conference) entryPoint(entryPointType string) *ConferenceEntryPoint {
+	for i := range c.EntryPoints {
+		if c.EntryPoints[i].Type == entryPointType {
+			return &c.EntryPoints[i]
+		}
+	}
+	return nil
+}
+
+// GetEventConference returns the conference of an event, or nil if the event has none
+func (c *client) GetEventConference(eventID string) (*Conference, error) {
+	_, event, err := c.getEvent(eventID)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetEventConference")
+	}
+
+	return convertGCalEventToConference(event), nil
+}
+
+// convertGCalEventToConference returns the conference of the event with all of its entry points,
+// falling back to the legacy hangout link. It returns nil if the event has no conference.
+func convertGCalEventToConference(event *calendar.Event) *Conference {
+	if event.ConferenceData != nil && len(event.ConferenceData.EntryPoints) > 0 {
+		conference := &Conference{}
+		if event.ConferenceData.ConferenceSolution != nil {
+			conference.Application = event.ConferenceData.ConferenceSolution.Name
+		}
+
+		for _, entryPoint := range event.ConferenceData.EntryPoints {
+			if entryPoint == nil {
+				continue
+			}
+			conference.EntryPoints = append(conference.EntryPoints, convertGCalEntryPoint(entryPoint))
+		}
+
+		return conference
+	}
+
+	if event.HangoutLink != "" {
+		return &Conference{
+			Application: hangoutLinkApplication,
+			EntryPoints: []ConferenceEntryPoint{{
+				Type:  EntryPointTypeVideo,
+				Label: event.HangoutLink,
+				URI:   event.HangoutLink,
+			}},
+		}
+	}
+
+	return nil
+}
+
+func convertGCalEntryPoint(entryPoint *calendar.EntryPoint) ConferenceEntryPoint {
+	// Each conference solution names the code to join with differently
+	passcode := entryPoint.Passcode
+	for _, code := range []string{entryPoint.Password, entryPoint.AccessCode, entryPoint.MeetingCode} {
+		if passcode == "" {
+			passcode = code
+		}
+	}
+
+	return ConferenceEntryPoint{
+		Type:     entryPoint.EntryPointType,
+		Label:    entryPoint.Label,
+		URI:      entryPoint.Uri,
+		Passcode: passcode,
+		Pin:      entryPoint.Pin,
+	}
+}
+
+// MattermostCallsApplication is the name of the conference solution of the events with a Mattermost call
+const MattermostCallsApplication = "Mattermost Calls"
+
+// mattermostCallsLabel introduces the link to the Mattermost call in the description of the event. Google only
+// accepts conference data from its own solutions and from Workspace add-ons, so the link is kept in the description.
+const mattermostCallsLabel = "Join the call in Mattermost:"
+
+// mattermostCallsPattern matches the link to the Mattermost call, also once Google Calendar turned it into HTML
+var mattermostCallsPattern = regexp.MustCompile(regexp.QuoteMeta(mattermostCallsLabel) + `\s*(?:<a [^>]*href=")?(https?://[^\s"'<>]+)`)
+
+// addMattermostCallToDescription returns the description of an event with a link to the Mattermost call
+func addMattermostCallToDescription(description, callURL string) string {
+	if description != "" {
+		description += "\n\n"
+	}
+	return description + mattermostCallsLabel + " " + callURL
+}
+
+// detectMattermostCall returns the Mattermost call linked from the description of an event, or nil if there is none
+func detectMattermostCall(description string) *remote.Conference {
+	match := mattermostCallsPattern.FindStringSubmatch(description)
+	if match == nil {
+		return nil
+	}
+
+	return &remote.Conference{
+		URL:         match[1],
+		Application: MattermostCallsApplication,
+	}
+}
+
+// newGoogleMeetConferenceData returns the conference data that asks Google to create a Google Meet conference
+func newGoogleMeetConferenceData() *calendar.ConferenceData {
+	return &calendar.ConferenceData{
+		CreateRequest: &calendar.CreateConferenceRequest{
+			RequestId: model.NewId(),
+			ConferenceSolutionKey: &calendar.ConferenceSolutionKey{
+				Type: conferenceSolutionGoogleMeet,
+			},
+		},
+	}
+}
+
+// conferenceRequestStatus returns the status of the conference creation requested for the event, if any
+func conferenceRequestStatus(event *calendar.Event) string {
+	if event.ConferenceData == nil || event.ConferenceData.CreateRequest == nil || event.ConferenceData.CreateRequest.Status == nil {
+		return ""
+	}
+	return event.ConferenceData.CreateRequest.Status.StatusCode
+}
+
+// isConferencePending returns true if Google is still creating the conference of the event
+func isConferencePending(event *calendar.Event) bool {
+	return conferenceRequestStatus(event) == conferenceStatusPending
+}
+
+// waitForConference reads the event again until its conference is created. The event is returned as is
+// if the conference is still pending after a few attempts.
+func (c *client) waitForConference(service *calendar.Service, calendarID string, event *calendar.Event) *calendar.Event {
+	for attempt := 1; attempt <= conferenceWaitAttempts; attempt++ {
+		time.Sleep(time.Duration(attempt) * conferenceWaitInterval)
+
+		updated, err := service.Events.Get(calendarID, event.Id).Do()
+		if err != nil {
+			c.Logger.Warnf("gcal: error getting event while waiting for its conference. err=%v", err)
+			return event
+		}
+
+		if !isConferencePending(updated) {
+			if status := conferenceRequestStatus(updated); status != conferenceStatusSuccess {
+				c.Logger.With(bot.LogContext{
+					"eventID": event.Id,
+					"status":  status,
+				}).Warnf("gcal: conference could not be created.")
+			}
+			return updated
+		}
+	}
+
+	c.Logger.With(bot.LogContext{
+		"eventID": event.Id,
+	}).Warnf("gcal: conference is still pending.")
+
+	return event
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+// ConferenceDetector finds the link of an externally hosted meeting in the description of an event
+type ConferenceDetector interface {
+	// Detect returns the conference found in the description, or nil if there is none
+	Detect(description string) *remote.Conference
+}
+
+// patternConferenceDetector detects the links of a meeting provider with a regular expression
+type patternConferenceDetector struct {
+	application string
+	pattern     *regexp.Regexp
+}
+
+// NewPatternConferenceDetector returns a detector for the links matching the pattern, reported as
+// conferences of the given application
+func NewPatternConferenceDetector(application, pattern string) (ConferenceDetector, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern for %s conferences", application)
+	}
+
+	return &patternConferenceDetector{
+		application: application,
+		pattern:     re,
+	}, nil
+}
+
+func (d *patternConferenceDetector) Detect(description string) *remote.Conference {
+	url := d.pattern.FindString(description)
+	if url == "" {
+		return nil
+	}
+
+	return &remote.Conference{
+		URL:         strings.TrimRight(url, ".,;:!?)]"),
+		Application: d.application,
+	}
+}
+
+// meetingURLChars matches the rest of a meeting link, up to the end of the text or of the HTML attribute
+const meetingURLChars = `[^\s"'<>]*`
+
+// conferenceDetectors are run in order on the descriptions of the events without conference data
+var conferenceDetectors = []ConferenceDetector{
+	mustNewPatternConferenceDetector("Zoom", `https://([a-z0-9-]+\.)?zoom(gov)?\.(us|com)/(j|my|w|s)/`+meetingURLChars),
+	mustNewPatternConferenceDetector("Microsoft Teams", `https://teams\.(microsoft|live)\.com/(l/meetup-join|meet)/`+meetingURLChars),
+	mustNewPatternConferenceDetector("Webex", `https://[a-z0-9-]+\.webex\.com/(meet/|join/|[a-z0-9-]+/j\.php)`+meetingURLChars),
+}
+
+func mustNewPatternConferenceDetector(application, pattern string) ConferenceDetector {
+	detector, err := NewPatternConferenceDetector(application, pattern)
+	if err != nil {
+		panic(err)
+	}
+	return detector
+}
+
+// RegisterConferenceDetector adds a detector for another meeting provider. It is meant to be called
+// when the plugin is activated, before any event is read.
+func RegisterConferenceDetector(detector ConferenceDetector) {
+	conferenceDetectors = append(conferenceDetectors, detector)
+}
+
+// detectConference returns the first conference found in the description of an event, or nil if there is none
+func detectConference(description string) *remote.Conference {
+	if description == "" {
+		return nil
+	}
+
+	// Descriptions are usually HTML, with escaped characters in the links
+	text := html.UnescapeString(description)
+	for _, detector := range conferenceDetectors {
+		if conference := detector.Detect(text); conference != nil {
+			return conference
+		}
+	}
+
+	return nil
+}
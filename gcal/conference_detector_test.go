@@ -0,0 +1,75 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectConference(t *testing.T) {
+	tcs := []struct {
+		Name        string
+		Description string
+		URL         string
+		Application string
+	}{
+		{
+			Name:        "no description",
+			Description: "",
+		},
+		{
+			Name:        "no meeting link",
+			Description: "Agenda: https://docs.example.com/agenda",
+		},
+		{
+			Name:        "zoom link in text",
+			Description: "Join Zoom Meeting\nhttps://zoom.us/j/123456789?pwd=abc.\nMeeting ID: 123 456 789",
+			URL:         "https://zoom.us/j/123456789?pwd=abc",
+			Application: "Zoom",
+		},
+		{
+			Name:        "teams link in html",
+			Description: `<a href="https://teams.microsoft.com/l/meetup-join/19%3ameeting_abc%40thread.v2/0?context=%7b%7d&amp;tenant=1">Join the meeting now</a>`,
+			URL:         "https://teams.microsoft.com/l/meetup-join/19%3ameeting_abc%40thread.v2/0?context=%7b%7d&tenant=1",
+			Application: "Microsoft Teams",
+		},
+		{
+			Name:        "webex link",
+			Description: "Meeting link: (https://example.webex.com/meet/jdoe)",
+			URL:         "https://example.webex.com/meet/jdoe",
+			Application: "Webex",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			conference := detectConference(tc.Description)
+			if tc.URL == "" {
+				require.Nil(t, conference)
+				return
+			}
+
+			require.Equal(t, tc.URL, conference.URL)
+			require.Equal(t, tc.Application, conference.Application)
+		})
+	}
+}
+
+func TestRegisterConferenceDetector(t *testing.T) {
+	defaultDetectors := conferenceDetectors
+	defer func() { conferenceDetectors = defaultDetectors }()
+
+	_, err := NewPatternConferenceDetector("Broken", `https://(`)
+	require.Error(t, err)
+
+	detector, err := NewPatternConferenceDetector("Jitsi", `https://meet\.jit\.si/`+meetingURLChars)
+	require.NoError(t, err)
+	RegisterConferenceDetector(detector)
+
+	conference := detectConference("Call: https://meet.jit.si/standup")
+	require.Equal(t, "https://meet.jit.si/standup", conference.URL)
+	require.Equal(t, "Jitsi", conference.Application)
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+func TestIsConferencePending(t *testing.T) {
+	event := createMinimalCalendarEvent()
+	require.False(t, isConferencePending(&event))
+
+	event.ConferenceData = newGoogleMeetConferenceData()
+	require.NotEmpty(t, event.ConferenceData.CreateRequest.RequestId)
+	require.Equal(t, conferenceSolutionGoogleMeet, event.ConferenceData.CreateRequest.ConferenceSolutionKey.Type)
+	require.False(t, isConferencePending(&event))
+
+	event.ConferenceData.CreateRequest.Status = &calendar.ConferenceRequestStatus{StatusCode: conferenceStatusPending}
+	require.True(t, isConferencePending(&event))
+
+	event.ConferenceData.CreateRequest.Status.StatusCode = conferenceStatusSuccess
+	require.False(t, isConferencePending(&event))
+}
+
+func TestCreateEventWithGoogleMeet(t *testing.T) {
+	pending := &calendar.Event{
+		Id:      "event_id",
+		Summary: "Release review",
+		ConferenceData: &calendar.ConferenceData{
+			CreateRequest: &calendar.CreateConferenceRequest{
+				Status: &calendar.ConferenceRequestStatus{StatusCode: conferenceStatusPending},
+			},
+		},
+	}
+	created := &calendar.Event{
+		Id:      "event_id",
+		Summary: "Release review",
+		ConferenceData: &calendar.ConferenceData{
+			CreateRequest: &calendar.CreateConferenceRequest{
+				Status: &calendar.ConferenceRequestStatus{StatusCode: conferenceStatusSuccess},
+			},
+			ConferenceSolution: &calendar.ConferenceSolution{Name: "Google Meet"},
+			EntryPoints: []*calendar.EntryPoint{
+				{EntryPointType: EntryPointTypeVideo, Uri: "https://meet.google.com/abc-defg-hij"},
+			},
+		},
+	}
+
+	var inserted *calendar.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/calendar/v3/calendars/primary/events":
+			require.Equal(t, "1", r.URL.Query().Get("conferenceDataVersion"))
+			inserted = &calendar.Event{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(inserted))
+			_ = json.NewEncoder(w).Encode(pending)
+		case r.Method == http.MethodGet && r.URL.Path == "/calendar/v3/calendars/primary/events/event_id":
+			_ = json.NewEncoder(w).Encode(created)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	c := &client{httpClient: &http.Client{Transport: &redirectTransport{url: serverURL}}}
+
+	event, err := c.CreateEventWithOptions(&remote.Event{Subject: "Release review"}, &EventOptions{AddGoogleMeet: true})
+	require.NoError(t, err)
+	require.Equal(t, conferenceSolutionGoogleMeet, inserted.ConferenceData.CreateRequest.ConferenceSolutionKey.Type)
+	require.Equal(t, &remote.Conference{URL: "https://meet.google.com/abc-defg-hij", Application: "Google Meet"}, event.Conference)
+}
+
+func TestDetectMattermostCall(t *testing.T) {
+	callURL := "https://mattermost.example.com/team/channels/town-square"
+
+	for name, tc := range map[string]struct {
+		description string
+		expectedURL string
+	}{
+		"no call":          {description: "Weekly sync"},
+		"added call":       {description: addMattermostCallToDescription("", callURL), expectedURL: callURL},
+		"added to details": {description: addMattermostCallToDescription("Weekly sync", callURL), expectedURL: callURL},
+		"edited in google": {description: `Weekly sync<br><br>Join the call in Mattermost: <a href="` + callURL + `">` + callURL + `</a>`, expectedURL: callURL},
+	} {
+		t.Run(name, func(t *testing.T) {
+			conference := detectMattermostCall(tc.description)
+			if tc.expectedURL == "" {
+				require.Nil(t, conference)
+				return
+			}
+			require.Equal(t, tc.expectedURL, conference.URL)
+			require.Equal(t, MattermostCallsApplication, conference.Application)
+		})
+	}
+}
@@ -5,6 +5,10 @@ package gcal
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -14,12 +18,161 @@ import (
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
 )
 
+// Notices posted to the channel the calendar of a cancelled event is linked to
+const (
+	eventCancelledMessage     = "The event **%s** on %s has been cancelled by the organizer."
+	seriesCancelledMessage    = "The recurring event **%s** has been cancelled by the organizer."
+	followingCancelledMessage = "The recurring event **%s** has been cancelled by the organizer from %s on."
+)
+
+// Values of the SendUpdates parameter, which controls the emails sent to the attendees of an event when it changes
+const (
+	sendUpdatesAll  = "all"
+	sendUpdatesNone = "none"
+)
+
+// EventOptions are the settings of a created event that are specific to Google
+type EventOptions struct {
+	// CalendarID is the calendar the event is created on, the primary calendar if empty
+	CalendarID string
+
+	// Recurrence makes the event repeat
+	Recurrence *Recurrence
+
+	// AddGoogleMeet creates a Google Meet conference for the event
+	AddGoogleMeet bool
+
+	// MattermostCallURL is the URL of the channel the event call is held in, linked from the event location
+	// and description
+	MattermostCallURL string
+
+	// ReminderMinutes are the offsets of the reminders replacing the calendar default reminders.
+	// An empty list removes all the reminders.
+	ReminderMinutes []int
+}
+
+// Event is an event of the user. remote.Event has no room for the Google specific details, so they are kept
+// alongside it.
+type Event struct {
+	*remote.Event
+
+	// CalendarID is the calendar the event was found on
+	CalendarID string
+
+	// RecurringEventID is the recurring event the event is an occurrence of, empty if it doesn't repeat
+	RecurringEventID string
+
+	// OriginalStartTime is where the occurrence falls in the series, even if it was moved
+	OriginalStartTime *remote.DateTime
+
+	// TimeZone is the time zone the event was scheduled in, empty if it has none. The start and end of
+	// remote.Event are in UTC.
+	TimeZone string
+}
+
+// IsOccurrence returns true if the event is an occurrence of a recurring event
+func (e *Event) IsOccurrence() bool {
+	return e.RecurringEventID != ""
+}
+
+// GetEvent returns an event of the user. The event is looked up in the primary calendar first, and then
+// in the rest of the selected calendars. Deleted events are returned as cancelled while Google keeps them.
 func (c *client) GetEvent(remoteUserID, eventID string) (*remote.Event, error) {
-	return nil, errors.New("gcal GetEvent not implemented")
+	calendarID, event, err := c.getEvent(eventID)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetEvent")
+	}
+	c.resolveDefaultReminders(calendarID, event)
+
+	result := convertGCalEventToRemoteEvent(event)
+	c.applyReminderPreference(result)
+
+	return result, nil
 }
 
-// CreateEvent creates a calendar event
+// GetEventDetails returns an event of the user along with its Google specific details
+func (c *client) GetEventDetails(eventID string) (*Event, error) {
+	calendarID, event, err := c.getEvent(eventID)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetEventDetails")
+	}
+	c.resolveDefaultReminders(calendarID, event)
+
+	details := convertGCalEventToEvent(calendarID, event)
+	c.applyReminderPreference(details.Event)
+
+	return details, nil
+}
+
+// EventIDFromLink returns the ID of the event a Google Calendar link points to. Anything else is returned as is,
+// so event IDs can be given directly.
+func EventIDFromLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	eid := strings.TrimRight(u.Query().Get("eid"), "=")
+	if eid == "" {
+		return link
+	}
+
+	// The eid parameter holds the event ID and the calendar ID, separated by a space
+	decoded, err := base64.RawURLEncoding.DecodeString(eid)
+	if err != nil {
+		decoded, err = base64.RawStdEncoding.DecodeString(eid)
+		if err != nil {
+			return link
+		}
+	}
+
+	eventID, _, _ := strings.Cut(string(decoded), " ")
+	return eventID
+}
+
+// getEvent returns an event of the user along with the ID of the calendar it was found on
+func (c *client) getEvent(eventID string) (string, *calendar.Event, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "error creating service")
+	}
+
+	event, err := service.Events.Get(defaultCalendarName, eventID).Do()
+	if err == nil {
+		return defaultCalendarName, event, nil
+	}
+	if !isNotFoundError(err) {
+		return "", nil, errors.Wrap(err, "error getting event")
+	}
+
+	calendarIDs, err := c.getSelectedCalendarIDs()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "error getting selected calendars")
+	}
+
+	for _, calendarID := range calendarIDs {
+		if calendarID == defaultCalendarName {
+			continue
+		}
+
+		event, err = service.Events.Get(calendarID, eventID).Do()
+		if err == nil {
+			return calendarID, event, nil
+		}
+		if !isNotFoundError(err) {
+			return "", nil, errors.Wrapf(err, "error getting event from calendar %s", calendarID)
+		}
+	}
+
+	return "", nil, errors.Errorf("event %s not found", eventID)
+}
+
+// CreateEvent creates a calendar event in the user's primary calendar
 func (c *client) CreateEvent(in *remote.Event) (*remote.Event, error) {
+	return c.CreateEventWithOptions(in, nil)
+}
+
+// CreateEventWithOptions creates a calendar event with the Google specific options that remote.Event has no room for
+func (c *client) CreateEventWithOptions(in *remote.Event, opts *EventOptions) (*remote.Event, error) {
 	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
 	if err != nil {
 		return nil, errors.Wrap(err, "gcal CreateEvent, error creating service")
@@ -27,63 +180,382 @@ func (c *client) CreateEvent(in *remote.Event) (*remote.Event, error) {
 
 	evt := convertRemoteEventToGcalEvent(in)
 
+	calendarID := defaultCalendarName
+	var reminderMinutes []int
+	if in.ReminderMinutesBeforeStart > 0 {
+		reminderMinutes = []int{in.ReminderMinutesBeforeStart}
+	}
+
+	if opts != nil {
+		if opts.CalendarID != "" {
+			calendarID = opts.CalendarID
+		}
+
+		if opts.AddGoogleMeet && opts.MattermostCallURL != "" {
+			return nil, errors.New("gcal CreateEvent, an event can't have both a Google Meet conference and a Mattermost call")
+		}
+
+		if opts.AddGoogleMeet {
+			evt.ConferenceData = newGoogleMeetConferenceData()
+		}
+
+		if opts.MattermostCallURL != "" {
+			evt.Description = addMattermostCallToDescription(evt.Description, opts.MattermostCallURL)
+			if evt.Location == "" {
+				evt.Location = opts.MattermostCallURL
+			}
+		}
+
+		if opts.Recurrence != nil {
+			isAllDay := evt.Start != nil && evt.Start.Date != ""
+			evt.Recurrence, err = opts.Recurrence.Rules(isAllDay)
+			if err != nil {
+				return nil, errors.Wrap(err, "gcal CreateEvent, invalid recurrence")
+			}
+		}
+
+		if opts.ReminderMinutes != nil {
+			reminderMinutes = opts.ReminderMinutes
+		}
+	}
+
+	if reminderMinutes != nil {
+		evt.Reminders, err = newReminderOverrides(reminderMinutes)
+		if err != nil {
+			return nil, errors.Wrap(err, "gcal CreateEvent, invalid reminders")
+		}
+	}
+
 	resultEvent, err := service.Events.
-		Insert(defaultCalendarName, evt).
-		SendUpdates("all"). // Send notifications to all attendees.
+		Insert(calendarID, evt).
+		SendUpdates(sendUpdatesAll).                  // Send notifications to all attendees.
+		ConferenceDataVersion(conferenceDataVersion). // Allow creating conferences.
 		Do()
 	if err != nil {
 		return nil, errors.Wrap(err, "gcal CreateEvent")
 	}
+	c.expireEventCache(calendarID)
+
+	if isConferencePending(resultEvent) {
+		resultEvent = c.waitForConference(service, calendarID, resultEvent)
+	}
 
 	return convertGCalEventToRemoteEvent(resultEvent), nil
 }
 
+// UpdateEvent changes an event of the user. Only the start, end, subject, body, location and attendees
+// set in the given event are changed.
+func (c *client) UpdateEvent(eventID string, in *remote.Event, notifyAttendees bool) (*remote.Event, error) {
+	calendarID, event, err := c.getEvent(eventID)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal UpdateEvent")
+	}
+
+	result, err := c.patchEvent(calendarID, event, convertRemoteEventToGcalEvent(in), notifyAttendees)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal UpdateEvent")
+	}
+
+	return convertGCalEventToRemoteEvent(result), nil
+}
+
+// RescheduleEvent moves an event of the user to a new start time, keeping its duration
+func (c *client) RescheduleEvent(eventID string, start time.Time, notifyAttendees bool) (*remote.Event, error) {
+	calendarID, event, err := c.getEvent(eventID)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal RescheduleEvent")
+	}
+
+	newStart, newEnd, err := rescheduleEventDateTimes(event, start)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal RescheduleEvent")
+	}
+
+	result, err := c.patchEvent(calendarID, event, &calendar.Event{Start: newStart, End: newEnd}, notifyAttendees)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal RescheduleEvent")
+	}
+
+	return convertGCalEventToRemoteEvent(result), nil
+}
+
+func (c *client) patchEvent(calendarID string, event, patch *calendar.Event, notifyAttendees bool) (*calendar.Event, error) {
+	// Google decides for secondary calendars, where the access role of the user matters
+	isOrganizer := event.Organizer != nil && event.Organizer.Self
+	if calendarID == defaultCalendarName && !isOrganizer && !event.GuestsCanModify {
+		return nil, errors.New("only the organizer can modify the event")
+	}
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating service")
+	}
+
+	sendUpdates := sendUpdatesNone
+	if notifyAttendees {
+		sendUpdates = sendUpdatesAll
+	}
+
+	result, err := service.Events.
+		Patch(calendarID, event.Id, patch).
+		SendUpdates(sendUpdates).
+		Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "error updating event")
+	}
+	c.expireEventCache(calendarID)
+
+	return result, nil
+}
+
+// rescheduleEventDateTimes returns the new start and end of an event moved to a new start time. All-day
+// events are moved to the day of the new start time.
+func rescheduleEventDateTimes(event *calendar.Event, start time.Time) (*calendar.EventDateTime, *calendar.EventDateTime, error) {
+	if event.Start == nil || event.End == nil {
+		return nil, nil, errors.New("the event has no start or end")
+	}
+
+	if event.Start.Date != "" {
+		oldStart, err := time.Parse(time.DateOnly, event.Start.Date)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error parsing event start")
+		}
+		oldEnd, err := time.Parse(time.DateOnly, event.End.Date)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error parsing event end")
+		}
+
+		newStart := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		days := int(oldEnd.Sub(oldStart).Hours() / 24)
+		return &calendar.EventDateTime{Date: newStart.Format(time.DateOnly)},
+			&calendar.EventDateTime{Date: newStart.AddDate(0, 0, days).Format(time.DateOnly)},
+			nil
+	}
+
+	oldStart, err := time.Parse(time.RFC3339, event.Start.DateTime)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error parsing event start")
+	}
+	oldEnd, err := time.Parse(time.RFC3339, event.End.DateTime)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error parsing event end")
+	}
+
+	return &calendar.EventDateTime{DateTime: start.Format(time.RFC3339), TimeZone: event.Start.TimeZone},
+		&calendar.EventDateTime{DateTime: start.Add(oldEnd.Sub(oldStart)).Format(time.RFC3339), TimeZone: event.End.TimeZone},
+		nil
+}
+
+// CancelEvent removes an event from the calendar of the user. Events organized by the user are cancelled for every
+// attendee, optionally posting a notice to the channel the calendar is linked to. Events organized by someone else
+// are only removed from the calendar of the user, which Google handles as declining the invitation.
+func (c *client) CancelEvent(eventID string, notifyAttendees, notifyChannel bool) error {
+	calendarID, event, err := c.getEvent(eventID)
+	if err != nil {
+		return errors.Wrap(err, "gcal CancelEvent")
+	}
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return errors.Wrap(err, "gcal CancelEvent, error creating service")
+	}
+
+	// The organizer is "self" when the event belongs to the calendar it was read from
+	isOrganizer := event.Organizer != nil && event.Organizer.Self
+
+	sendUpdates := sendUpdatesNone
+	if isOrganizer && notifyAttendees {
+		sendUpdates = sendUpdatesAll
+	}
+
+	err = service.Events.Delete(calendarID, event.Id).SendUpdates(sendUpdates).Do()
+	if err != nil && !isNotFoundError(err) {
+		return errors.Wrap(err, "gcal CancelEvent, error deleting event")
+	}
+	c.expireEventCache(calendarID)
+
+	if !isOrganizer || !notifyChannel {
+		return nil
+	}
+
+	err = c.postToCalendarChannel(calendarID, fmt.Sprintf(eventCancelledMessage, event.Summary, formatEventStart(event)))
+	if err != nil {
+		return errors.Wrap(err, "gcal CancelEvent")
+	}
+
+	return nil
+}
+
+// postToCalendarChannel posts a notice to the channel the calendar is linked to, if any
+func (c *client) postToCalendarChannel(calendarID, message string) error {
+	channelID, err := loadCalendarChannelID(calendarID)
+	if err != nil {
+		return errors.Wrap(err, "error loading calendar channel")
+	}
+	if channelID == "" {
+		return nil
+	}
+
+	err = c.postToChannel(channelID, message)
+	if err != nil {
+		return errors.Wrap(err, "error posting notice")
+	}
+
+	return nil
+}
+
+// formatEventStart returns a human readable start of an event, in the time zone of the event
+func formatEventStart(event *calendar.Event) string {
+	if event.Start == nil {
+		return ""
+	}
+
+	if event.Start.Date != "" {
+		t, err := time.Parse(time.DateOnly, event.Start.Date)
+		if err != nil {
+			return event.Start.Date
+		}
+		return t.Format("Monday, January 2")
+	}
+
+	t, err := time.Parse(time.RFC3339, event.Start.DateTime)
+	if err != nil {
+		return event.Start.DateTime
+	}
+	if loc, err := time.LoadLocation(event.Start.TimeZone); err == nil {
+		t = t.In(loc)
+	}
+	return t.Format("Monday, January 2 at 15:04 MST")
+}
+
+// AcceptEvent accepts an event invitation, notifying the organizer
 func (c *client) AcceptEvent(remoteUserID, eventID string) error {
-	return errors.New("gcal AcceptEvent not implemented")
+	return c.RespondToEvent(eventID, GoogleResponseStatusYes, "", true)
 }
 
+// DeclineEvent declines an event invitation, notifying the organizer
 func (c *client) DeclineEvent(remoteUserID, eventID string) error {
-	return errors.New("gcal DeclineEvent not implemented")
+	return c.RespondToEvent(eventID, GoogleResponseStatusNo, "", true)
 }
 
+// TentativelyAcceptEvent tentatively accepts an event invitation, notifying the organizer
 func (c *client) TentativelyAcceptEvent(eventID string) error {
-	return errors.New("gcal TentativelyAcceptEvent not implemented")
+	return c.RespondToEvent(eventID, GoogleResponseStatusMaybe, "", true)
 }
 
-func (c *client) GetEventsBetweenDates(_ string, start, end time.Time) (events []*remote.Event, err error) {
-	ctx := context.Background()
-	service, err := calendar.NewService(ctx, option.WithHTTPClient(c.httpClient))
+// RespondToEvent sets the response of the user to an event invitation, with an optional comment for the organizer
+func (c *client) RespondToEvent(eventID, response, comment string, notifyOrganizer bool) error {
+	calendarID, event, err := c.getEvent(eventID)
 	if err != nil {
-		return nil, errors.Wrap(err, "gcal CreateEvent, error creating service")
+		return errors.Wrap(err, "gcal RespondToEvent")
 	}
 
-	result, err := service.Events.
-		List(defaultCalendarName).
-		EventTypes("default").
-		TimeMin(start.Format(time.RFC3339)).
-		TimeMax(end.Format(time.RFC3339)).
-		OrderBy("startTime").
-		SingleEvents(true).
-		ShowDeleted(false).
-		ShowHiddenInvitations(false).
-		Context(ctx).
+	attendees, changed, err := setSelfResponse(event, response, comment)
+	if err != nil {
+		return errors.Wrap(err, "gcal RespondToEvent")
+	}
+	if !changed {
+		return nil
+	}
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return errors.Wrap(err, "gcal RespondToEvent, error creating service")
+	}
+
+	sendUpdates := sendUpdatesNone
+	if notifyOrganizer {
+		sendUpdates = sendUpdatesAll
+	}
+
+	// The attendee list is replaced as a whole, so it's sent with only the user response changed
+	_, err = service.Events.
+		Patch(calendarID, event.Id, &calendar.Event{Attendees: attendees}).
+		SendUpdates(sendUpdates).
 		Do()
 	if err != nil {
-		return nil, errors.Wrap(err, "error getting list of events")
+		return errors.Wrap(err, "gcal RespondToEvent, error updating event")
 	}
+	c.expireEventCache(calendarID)
 
-	for _, evt := range result.Items {
-		events = append(events, convertGCalEventToRemoteEvent(evt))
+	return nil
+}
+
+// setSelfResponse returns the attendees of the event with the response of the user changed, and whether
+// there was any change at all. Organizers can't respond to their own events, other than accepting them.
+func setSelfResponse(event *calendar.Event, response, comment string) ([]*calendar.EventAttendee, bool, error) {
+	isOrganizer := event.Organizer != nil && event.Organizer.Self
+
+	attendees := make([]*calendar.EventAttendee, 0, len(event.Attendees))
+	found := false
+	changed := false
+	for _, attendee := range event.Attendees {
+		if !attendee.Self {
+			attendees = append(attendees, attendee)
+			continue
+		}
+
+		found = true
+		isOrganizer = isOrganizer || attendee.Organizer
+
+		updated := *attendee
+		updated.ResponseStatus = response
+		if comment != "" {
+			updated.Comment = comment
+		}
+		changed = updated.ResponseStatus != attendee.ResponseStatus || updated.Comment != attendee.Comment
+		attendees = append(attendees, &updated)
+	}
+
+	if isOrganizer {
+		if response == GoogleResponseStatusYes {
+			return nil, false, nil
+		}
+		return nil, false, errors.New("the organizer can't respond to their own event")
+	}
+
+	if !found {
+		return nil, false, errors.New("the user is not an attendee of the event")
+	}
+
+	return attendees, changed, nil
+}
+
+// GetEventsBetweenDates returns the events of all the calendars selected by the user between two dates
+func (c *client) GetEventsBetweenDates(_ string, start, end time.Time) (events []*remote.Event, err error) {
+	events, err = c.getSelectedCalendarsEvents(start, end)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetEventsBetweenDates")
 	}
 
 	return events, nil
 }
 
+// convertGCalEventToEvent converts a Google event to a local representation event, keeping its recurrence linkage
+func convertGCalEventToEvent(calendarID string, event *calendar.Event) *Event {
+	details := &Event{
+		Event:            convertGCalEventToRemoteEvent(event),
+		CalendarID:       calendarID,
+		RecurringEventID: event.RecurringEventId,
+	}
+	if event.OriginalStartTime != nil {
+		details.OriginalStartTime = convertGCalEventDateTimeToRemoteDateTime(event.OriginalStartTime)
+	}
+	if event.Start != nil {
+		details.TimeZone = event.Start.TimeZone
+	}
+	return details
+}
+
 func convertRemoteEventToGcalEvent(in *remote.Event) *calendar.Event {
 	out := &calendar.Event{}
 	out.Summary = in.Subject
-	out.Start = convertRemoteDateTimeToGcalEventDateTime(in.Start)
-	out.End = convertRemoteDateTimeToGcalEventDateTime(in.End)
+	if in.Start != nil {
+		out.Start = convertRemoteDateTimeToGcalEventDateTime(in.Start)
+	}
+	if in.End != nil {
+		out.End = convertRemoteDateTimeToGcalEventDateTime(in.End)
+	}
 	if in.Body != nil {
 		out.Description = in.Body.Content
 	}
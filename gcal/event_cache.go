@@ -0,0 +1,464 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
+)
+
+const (
+	eventCacheKeyPrefix       = "gcal_event_cache_"
+	eventCacheSyncedKeyPrefix = "gcal_event_cache_synced_"
+
+	// eventCacheLookbehind and eventCacheLookahead bound the events kept in the cache around the time of the full sync
+	eventCacheLookbehind = 24 * time.Hour
+	eventCacheLookahead  = 30 * 24 * time.Hour
+
+	// eventCacheMaxAge is how long cached events are used before asking Google for the changes. The push
+	// notifications of the primary calendar expire its cache as soon as it changes, so its max age only bounds
+	// how long a lost notification goes unnoticed. The other calendars aren't watched.
+	eventCacheMaxAge          = time.Hour
+	unwatchedEventCacheMaxAge = 5 * time.Minute
+
+	// eventCacheMaxEvents bounds the size of the cache, the window is shortened to fit the first events
+	eventCacheMaxEvents = 1000
+)
+
+// eventCache is the copy of the events of a calendar kept in sync with Google through sync tokens
+type eventCache struct {
+	SyncToken string `json:"sync_token"`
+
+	// WindowStart and WindowEnd are the times the cached events are complete for
+	WindowStart int64 `json:"window_start"`
+	WindowEnd   int64 `json:"window_end"`
+
+	// TimeZone is the time zone of the calendar, which all-day events start and end in
+	TimeZone string `json:"time_zone"`
+
+	Events map[string]*calendar.Event `json:"events"`
+}
+
+// location returns the time zone of the calendar, UTC if it isn't known
+func (ec *eventCache) location() *time.Location {
+	loc, err := time.LoadLocation(ec.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// setTimeZone sets the time zone of the calendar from a page of its events
+func (ec *eventCache) setTimeZone(page *calendar.Events) {
+	if page.TimeZone != "" {
+		ec.TimeZone = page.TimeZone
+	}
+}
+
+// covers returns true if all the events between two dates are in the cache
+func (ec *eventCache) covers(start, end time.Time) bool {
+	return !start.Before(time.UnixMilli(ec.WindowStart)) && !end.After(time.UnixMilli(ec.WindowEnd))
+}
+
+// apply stores the changed events in the cache, dropping the cancelled ones and the ones out of its window
+func (ec *eventCache) apply(events []*calendar.Event) {
+	windowStart := time.UnixMilli(ec.WindowStart)
+	windowEnd := time.UnixMilli(ec.WindowEnd)
+	loc := ec.location()
+	for _, event := range events {
+		if event.Status == "cancelled" || !eventOverlaps(event, windowStart, windowEnd, loc) {
+			delete(ec.Events, event.Id)
+			continue
+		}
+		ec.Events[event.Id] = newCachedEvent(event)
+	}
+
+	if len(ec.Events) > eventCacheMaxEvents {
+		ec.shrink()
+	}
+}
+
+// full returns true if the cache holds as many events as it can, so its window can't be extended
+func (ec *eventCache) full() bool {
+	return len(ec.Events) >= eventCacheMaxEvents
+}
+
+// shrink ends the window of the cache at the start of the first event over the size limit, dropping the events
+// starting from then on
+func (ec *eventCache) shrink() {
+	loc := ec.location()
+	events := ec.between(time.UnixMilli(ec.WindowStart), time.UnixMilli(ec.WindowEnd))
+	windowEnd, err := parseEventDateTimeIn(events[eventCacheMaxEvents].Start, loc)
+	if err != nil {
+		return
+	}
+
+	ec.WindowEnd = windowEnd.UnixMilli()
+	for _, event := range events[eventCacheMaxEvents:] {
+		delete(ec.Events, event.Id)
+	}
+	for _, event := range events[:eventCacheMaxEvents] {
+		if !eventOverlaps(event, time.UnixMilli(ec.WindowStart), windowEnd, loc) {
+			delete(ec.Events, event.Id)
+		}
+	}
+}
+
+// newCachedEvent returns a copy of the event with only the details the plugin reads from the cache. Cached events
+// are stored in plain text, so descriptions and guest lists are left out: the description is only kept for the
+// conference link found in it. Conference entry points are kept, as reminders show how to dial in.
+func newCachedEvent(event *calendar.Event) *calendar.Event {
+	cached := &calendar.Event{
+		Id:                        event.Id,
+		ICalUID:                   event.ICalUID,
+		Status:                    event.Status,
+		EventType:                 event.EventType,
+		Summary:                   event.Summary,
+		Description:               conferenceDescription(event.Description),
+		Location:                  event.Location,
+		HtmlLink:                  event.HtmlLink,
+		HangoutLink:               event.HangoutLink,
+		Transparency:              event.Transparency,
+		Start:                     event.Start,
+		End:                       event.End,
+		RecurringEventId:          event.RecurringEventId,
+		OriginalStartTime:         event.OriginalStartTime,
+		Reminders:                 event.Reminders,
+		OutOfOfficeProperties:     event.OutOfOfficeProperties,
+		WorkingLocationProperties: event.WorkingLocationProperties,
+	}
+
+	if event.Organizer != nil {
+		cached.Organizer = &calendar.EventOrganizer{
+			Email: event.Organizer.Email,
+			Self:  event.Organizer.Self,
+		}
+	}
+
+	for _, attendee := range event.Attendees {
+		if attendee.Self {
+			cached.Attendees = []*calendar.EventAttendee{{
+				Email:          attendee.Email,
+				Self:           true,
+				Organizer:      attendee.Organizer,
+				ResponseStatus: attendee.ResponseStatus,
+			}}
+		}
+	}
+
+	if event.ConferenceData != nil {
+		conferenceData := &calendar.ConferenceData{}
+		if event.ConferenceData.ConferenceSolution != nil {
+			conferenceData.ConferenceSolution = &calendar.ConferenceSolution{
+				Name: event.ConferenceData.ConferenceSolution.Name,
+			}
+		}
+		for _, entryPoint := range event.ConferenceData.EntryPoints {
+			if entryPoint != nil {
+				conferenceData.EntryPoints = append(conferenceData.EntryPoints, &calendar.EntryPoint{
+					EntryPointType: entryPoint.EntryPointType,
+					Uri:            entryPoint.Uri,
+					Label:          entryPoint.Label,
+					Pin:            entryPoint.Pin,
+					Passcode:       entryPoint.Passcode,
+					Password:       entryPoint.Password,
+					AccessCode:     entryPoint.AccessCode,
+					MeetingCode:    entryPoint.MeetingCode,
+				})
+			}
+		}
+		if len(conferenceData.EntryPoints) > 0 {
+			cached.ConferenceData = conferenceData
+		}
+	}
+
+	return cached
+}
+
+// conferenceDescription returns the part of the description of an event the conference is detected from
+func conferenceDescription(description string) string {
+	if conference := detectMattermostCall(description); conference != nil {
+		return addMattermostCallToDescription("", conference.URL)
+	}
+	if conference := detectConference(description); conference != nil {
+		return conference.URL
+	}
+	return ""
+}
+
+// between returns the cached events taking place between two dates, sorted by start time
+func (ec *eventCache) between(start, end time.Time) []*calendar.Event {
+	loc := ec.location()
+	events := []*calendar.Event{}
+	for _, event := range ec.Events {
+		if eventOverlaps(event, start, end, loc) {
+			events = append(events, event)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		si, _ := parseEventDateTimeIn(events[i].Start, loc)
+		sj, _ := parseEventDateTimeIn(events[j].Start, loc)
+		if si.Equal(sj) {
+			return events[i].Id < events[j].Id
+		}
+		return si.Before(sj)
+	})
+
+	return events
+}
+
+// eventOverlaps returns true if the event takes place at least partly between two dates. All-day events take
+// place in the given location.
+func eventOverlaps(event *calendar.Event, start, end time.Time, loc *time.Location) bool {
+	eventStart, err := parseEventDateTimeIn(event.Start, loc)
+	if err != nil {
+		return false
+	}
+	eventEnd, err := parseEventDateTimeIn(event.End, loc)
+	if err != nil {
+		return false
+	}
+
+	return eventStart.Before(end) && eventEnd.After(start)
+}
+
+// eventCacheKey returns the key of the event cache of a user calendar. Calendar IDs are hashed since they
+// can be longer than a key.
+func eventCacheKey(prefix, mattermostUserID, calendarID string) string {
+	hash := sha256.Sum256([]byte(calendarID))
+	return prefix + mattermostUserID + "_" + hex.EncodeToString(hash[:16])
+}
+
+// listCachedEvents returns the single events of a calendar between two dates from the event cache of the user,
+// syncing it first with the changes made in Google. Dates out of the cache window are read from Google.
+func (c *client) listCachedEvents(ctx context.Context, service *calendar.Service, calendarID string, start, end time.Time) ([]*calendar.Event, error) {
+	if pluginAPI == nil || c.mattermostUserID == "" {
+		return listEvents(ctx, service, calendarID, start, end)
+	}
+
+	now := time.Now()
+	if start.Before(now.Add(-eventCacheLookbehind)) || end.After(now.Add(eventCacheLookahead)) {
+		return listEvents(ctx, service, calendarID, start, end)
+	}
+
+	cache, err := c.syncEventCache(ctx, service, calendarID, end)
+	if err != nil {
+		return nil, err
+	}
+
+	// A full cache can't be extended to cover the dates
+	if !cache.covers(start, end) {
+		return listEvents(ctx, service, calendarID, start, end)
+	}
+
+	return cache.between(start, end), nil
+}
+
+// syncEventCache returns the event cache of a calendar, updated with the changes made in Google since the last sync.
+// The window of the cache is extended to the end date when it falls out of it, unless the cache is full. All the
+// events are only read again when Google expired the sync token.
+func (c *client) syncEventCache(ctx context.Context, service *calendar.Service, calendarID string, end time.Time) (*eventCache, error) {
+	key := eventCacheKey(eventCacheKeyPrefix, c.mattermostUserID, calendarID)
+	syncedKey := eventCacheKey(eventCacheSyncedKeyPrefix, c.mattermostUserID, calendarID)
+
+	cache := &eventCache{}
+	found, err := kvGet(key, cache)
+	if err != nil {
+		c.Logger.Warnf("gcal: error loading event cache. err=%v", err)
+		found = false
+	}
+
+	if found && cache.SyncToken != "" {
+		err = c.updateEventCache(ctx, service, calendarID, cache, end)
+		if err == nil {
+			return cache, nil
+		}
+		if !isGoneError(err) {
+			return nil, errors.Wrap(err, "error syncing events")
+		}
+
+		c.Logger.With(bot.LogContext{
+			"calendarID": calendarID,
+		}).Debugf("gcal: sync token expired, syncing all events again.")
+	}
+
+	cache, err = fullSyncEvents(ctx, service, calendarID, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "error syncing events")
+	}
+	c.storeEventCache(key, cache)
+	c.storeEventCacheSyncTime(syncedKey)
+
+	return cache, nil
+}
+
+// updateEventCache applies the changes made in Google to the event cache of a calendar once it is too old, and
+// extends its window to the end date
+func (c *client) updateEventCache(ctx context.Context, service *calendar.Service, calendarID string, cache *eventCache, end time.Time) error {
+	key := eventCacheKey(eventCacheKeyPrefix, c.mattermostUserID, calendarID)
+	syncedKey := eventCacheKey(eventCacheSyncedKeyPrefix, c.mattermostUserID, calendarID)
+
+	var syncedAt int64
+	_, err := kvGet(syncedKey, &syncedAt)
+	if err != nil || time.Since(time.UnixMilli(syncedAt)) >= eventCacheMaxAgeOf(calendarID) {
+		err = incrementalSyncEvents(ctx, service, calendarID, cache)
+		if err != nil {
+			return err
+		}
+		c.storeEventCache(key, cache)
+		c.storeEventCacheSyncTime(syncedKey)
+	}
+
+	if end.After(time.UnixMilli(cache.WindowEnd)) && !cache.full() {
+		err = extendEventCache(ctx, service, calendarID, cache, time.Now().Add(eventCacheLookahead))
+		if err != nil {
+			return err
+		}
+		// The sync time is kept, the events of the former window are as old as before
+		c.storeEventCache(key, cache)
+	}
+
+	return nil
+}
+
+// eventCacheMaxAgeOf returns how long the cached events of a calendar are used before asking Google for the changes
+func eventCacheMaxAgeOf(calendarID string) time.Duration {
+	if calendarID == defaultCalendarName {
+		return eventCacheMaxAge
+	}
+	return unwatchedEventCacheMaxAge
+}
+
+func (c *client) storeEventCache(key string, cache *eventCache) {
+	err := kvSet(key, cache)
+	if err != nil {
+		c.Logger.Warnf("gcal: error storing event cache. err=%v", err)
+	}
+}
+
+func (c *client) storeEventCacheSyncTime(syncedKey string) {
+	err := kvSet(syncedKey, time.Now().UnixMilli())
+	if err != nil {
+		c.Logger.Warnf("gcal: error storing event cache sync time. err=%v", err)
+	}
+}
+
+// expireEventCache makes the next read of the calendar events ask Google for the changes, like the ones
+// the user just made through the plugin
+func (c *client) expireEventCache(calendarID string) {
+	if pluginAPI == nil || c.mattermostUserID == "" {
+		return
+	}
+
+	err := kvDelete(eventCacheKey(eventCacheSyncedKeyPrefix, c.mattermostUserID, calendarID))
+	if err != nil {
+		c.Logger.Warnf("gcal: error expiring event cache. err=%v", err)
+	}
+}
+
+// fullSyncEvents reads all the events of a calendar around the given time, along with the token to get their changes
+func fullSyncEvents(ctx context.Context, service *calendar.Service, calendarID string, now time.Time) (*eventCache, error) {
+	cache := &eventCache{
+		WindowStart: now.Add(-eventCacheLookbehind).UnixMilli(),
+		WindowEnd:   now.Add(eventCacheLookahead).UnixMilli(),
+		Events:      map[string]*calendar.Event{},
+	}
+
+	err := service.Events.
+		List(calendarID).
+		EventTypes(supportedEventTypes...).
+		TimeMin(time.UnixMilli(cache.WindowStart).Format(time.RFC3339)).
+		TimeMax(time.UnixMilli(cache.WindowEnd).Format(time.RFC3339)).
+		SingleEvents(true).
+		ShowDeleted(false).
+		ShowHiddenInvitations(false).
+		Pages(ctx, func(page *calendar.Events) error {
+			applyDefaultReminders(page.Items, page.DefaultReminders)
+			cache.setTimeZone(page)
+			cache.apply(page.Items)
+			if page.NextSyncToken != "" {
+				cache.SyncToken = page.NextSyncToken
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+// extendEventCache reads the events between the end of the cache window and the given date, and extends the window
+// to that date. Their later changes come with the next incremental sync.
+func extendEventCache(ctx context.Context, service *calendar.Service, calendarID string, cache *eventCache, end time.Time) error {
+	events := []*calendar.Event{}
+	err := service.Events.
+		List(calendarID).
+		EventTypes(supportedEventTypes...).
+		TimeMin(time.UnixMilli(cache.WindowEnd).Format(time.RFC3339)).
+		TimeMax(end.Format(time.RFC3339)).
+		SingleEvents(true).
+		ShowDeleted(false).
+		ShowHiddenInvitations(false).
+		Pages(ctx, func(page *calendar.Events) error {
+			applyDefaultReminders(page.Items, page.DefaultReminders)
+			cache.setTimeZone(page)
+			events = append(events, page.Items...)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	cache.WindowEnd = end.UnixMilli()
+	cache.apply(events)
+	return nil
+}
+
+// incrementalSyncEvents applies the changes made to the events of a calendar since the last sync
+func incrementalSyncEvents(ctx context.Context, service *calendar.Service, calendarID string, cache *eventCache) error {
+	if cache.Events == nil {
+		cache.Events = map[string]*calendar.Event{}
+	}
+
+	// Caches stored with whole events are trimmed as they are synced
+	for id, event := range cache.Events {
+		cache.Events[id] = newCachedEvent(event)
+	}
+
+	syncToken := ""
+	err := service.Events.
+		List(calendarID).
+		EventTypes(supportedEventTypes...).
+		SyncToken(cache.SyncToken).
+		SingleEvents(true).
+		ShowHiddenInvitations(false).
+		Pages(ctx, func(page *calendar.Events) error {
+			applyDefaultReminders(page.Items, page.DefaultReminders)
+			cache.setTimeZone(page)
+			cache.apply(page.Items)
+			if page.NextSyncToken != "" {
+				syncToken = page.NextSyncToken
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	if syncToken != "" {
+		cache.SyncToken = syncToken
+	}
+
+	return nil
+}
@@ -0,0 +1,326 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+func TestEventCache(t *testing.T) {
+	windowStart := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	newEvent := func(id, start, end string) *calendar.Event {
+		return &calendar.Event{
+			Id:     id,
+			Status: "confirmed",
+			Start:  &calendar.EventDateTime{DateTime: start},
+			End:    &calendar.EventDateTime{DateTime: end},
+		}
+	}
+
+	cache := &eventCache{
+		WindowStart: windowStart.UnixMilli(),
+		WindowEnd:   windowStart.Add(7 * 24 * time.Hour).UnixMilli(),
+		Events:      map[string]*calendar.Event{},
+	}
+
+	cache.apply([]*calendar.Event{
+		newEvent("review", "2023-08-02T15:00:00Z", "2023-08-02T16:00:00Z"),
+		newEvent("standup", "2023-08-02T09:00:00Z", "2023-08-02T09:15:00Z"),
+		newEvent("offsite", "2023-09-01T09:00:00Z", "2023-09-01T17:00:00Z"),
+		{Id: "all_day", Start: &calendar.EventDateTime{Date: "2023-08-02"}, End: &calendar.EventDateTime{Date: "2023-08-03"}},
+	})
+	require.Len(t, cache.Events, 3)
+	require.NotContains(t, cache.Events, "offsite")
+
+	// Incremental changes: an event moved, another cancelled and one moved out of the window
+	cache.apply([]*calendar.Event{
+		newEvent("standup", "2023-08-02T10:00:00Z", "2023-08-02T10:15:00Z"),
+		{Id: "review", Status: "cancelled"},
+		newEvent("all_day", "2023-10-01T09:00:00Z", "2023-10-01T10:00:00Z"),
+		newEvent("retro", "2023-08-03T14:00:00Z", "2023-08-03T15:00:00Z"),
+	})
+	require.Len(t, cache.Events, 2)
+	require.Equal(t, "2023-08-02T10:00:00Z", cache.Events["standup"].Start.DateTime)
+
+	ids := func(events []*calendar.Event) []string {
+		result := []string{}
+		for _, event := range events {
+			result = append(result, event.Id)
+		}
+		return result
+	}
+	require.Equal(t, []string{"standup", "retro"}, ids(cache.between(windowStart, windowStart.Add(7*24*time.Hour))))
+	require.Equal(t, []string{"retro"}, ids(cache.between(
+		time.Date(2023, 8, 3, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 8, 4, 0, 0, 0, 0, time.UTC),
+	)))
+
+	require.True(t, cache.covers(windowStart, windowStart.Add(24*time.Hour)))
+	require.False(t, cache.covers(windowStart.Add(-time.Hour), windowStart.Add(24*time.Hour)))
+	require.False(t, cache.covers(windowStart, windowStart.Add(8*24*time.Hour)))
+}
+
+func TestEventCacheAllDayEvents(t *testing.T) {
+	allDay := &calendar.Event{
+		Id:     "all_day",
+		Status: "confirmed",
+		Start:  &calendar.EventDateTime{Date: "2023-08-02"},
+		End:    &calendar.EventDateTime{Date: "2023-08-03"},
+	}
+
+	for name, tc := range map[string]struct {
+		timeZone string
+		at       time.Time
+		expected bool
+	}{
+		"utc during the day":       {timeZone: "UTC", at: time.Date(2023, 8, 2, 12, 0, 0, 0, time.UTC), expected: true},
+		"utc the day after":        {timeZone: "UTC", at: time.Date(2023, 8, 3, 3, 0, 0, 0, time.UTC), expected: false},
+		"unknown time zone":        {at: time.Date(2023, 8, 2, 0, 30, 0, 0, time.UTC), expected: true},
+		"west before the day":      {timeZone: "America/Los_Angeles", at: time.Date(2023, 8, 2, 3, 0, 0, 0, time.UTC), expected: false},
+		"west at the end of day":   {timeZone: "America/Los_Angeles", at: time.Date(2023, 8, 3, 3, 0, 0, 0, time.UTC), expected: true},
+		"east at the start of day": {timeZone: "Europe/Berlin", at: time.Date(2023, 8, 1, 23, 0, 0, 0, time.UTC), expected: true},
+		"east after the day":       {timeZone: "Europe/Berlin", at: time.Date(2023, 8, 2, 23, 0, 0, 0, time.UTC), expected: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			windowStart := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+			cache := &eventCache{
+				WindowStart: windowStart.UnixMilli(),
+				WindowEnd:   windowStart.Add(7 * 24 * time.Hour).UnixMilli(),
+				TimeZone:    tc.timeZone,
+				Events:      map[string]*calendar.Event{},
+			}
+			cache.apply([]*calendar.Event{allDay})
+
+			events := cache.between(tc.at, tc.at.Add(time.Minute))
+			if tc.expected {
+				require.Len(t, events, 1)
+			} else {
+				require.Empty(t, events)
+			}
+		})
+	}
+}
+
+func TestFullSyncEventsTimeZone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/calendar/v3/calendars/primary/events", r.URL.Path)
+		_, _ = w.Write([]byte(`{
+			"timeZone": "America/Los_Angeles",
+			"nextSyncToken": "token",
+			"items": [{"id": "all_day", "status": "confirmed", "start": {"date": "2023-08-02"}, "end": {"date": "2023-08-03"}}]
+		}`))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(&http.Client{Transport: &redirectTransport{url: serverURL}}))
+	require.NoError(t, err)
+
+	cache, err := fullSyncEvents(context.Background(), service, defaultCalendarName, time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, "America/Los_Angeles", cache.TimeZone)
+	require.Equal(t, "token", cache.SyncToken)
+	require.Empty(t, cache.between(time.Date(2023, 8, 2, 3, 0, 0, 0, time.UTC), time.Date(2023, 8, 2, 4, 0, 0, 0, time.UTC)))
+	require.Len(t, cache.between(time.Date(2023, 8, 3, 3, 0, 0, 0, time.UTC), time.Date(2023, 8, 3, 4, 0, 0, 0, time.UTC)), 1)
+}
+
+func TestListCachedEvents(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	now := time.Now().Truncate(time.Second)
+	windowEnd := now.Add(24 * time.Hour)
+	newEvent := func(id string, start time.Time) *calendar.Event {
+		return &calendar.Event{
+			Id:     id,
+			Status: "confirmed",
+			Start:  &calendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+			End:    &calendar.EventDateTime{DateTime: start.Add(time.Hour).Format(time.RFC3339)},
+		}
+	}
+	soon := newEvent("soon", now.Add(2*time.Hour))
+	later := newEvent("later", now.Add(3*24*time.Hour))
+
+	for name, tc := range map[string]struct {
+		syncedAt         time.Time
+		full             bool
+		end              time.Time
+		expected         []string
+		expectedRequests []string
+		expectedWindow   bool
+		expectedSynced   bool
+	}{
+		"covered and recently synced": {
+			syncedAt: now,
+			end:      now.Add(12 * time.Hour),
+			expected: []string{"soon"},
+		},
+		"covered and synced too long ago": {
+			syncedAt:         now.Add(-2 * eventCacheMaxAge),
+			end:              now.Add(12 * time.Hour),
+			expected:         []string{"soon"},
+			expectedRequests: []string{"sync"},
+			expectedSynced:   true,
+		},
+		"window extended": {
+			syncedAt:         now,
+			end:              now.Add(4 * 24 * time.Hour),
+			expected:         []string{"soon", "later"},
+			expectedRequests: []string{"extend"},
+			expectedWindow:   true,
+		},
+		"full cache": {
+			syncedAt:         now,
+			full:             true,
+			end:              now.Add(4 * 24 * time.Hour),
+			expected:         []string{"soon", "later"},
+			expectedRequests: []string{"list"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/calendar/v3/calendars/primary/events", r.URL.Path)
+				query := r.URL.Query()
+				switch {
+				case query.Get("syncToken") != "":
+					requests = append(requests, "sync")
+					_, _ = w.Write([]byte(`{"nextSyncToken": "next_token"}`))
+				case query.Get("timeMin") == windowEnd.Format(time.RFC3339):
+					requests = append(requests, "extend")
+					_ = json.NewEncoder(w).Encode(&calendar.Events{Items: []*calendar.Event{later}})
+				default:
+					requests = append(requests, "list")
+					_ = json.NewEncoder(w).Encode(&calendar.Events{Items: []*calendar.Event{soon, later}})
+				}
+			}))
+			defer server.Close()
+			serverURL, err := url.Parse(server.URL)
+			require.NoError(t, err)
+			service, err := calendar.NewService(context.Background(), option.WithHTTPClient(&http.Client{Transport: &redirectTransport{url: serverURL}}))
+			require.NoError(t, err)
+
+			cache := &eventCache{
+				SyncToken:   "token",
+				WindowStart: now.Add(-time.Hour).UnixMilli(),
+				WindowEnd:   windowEnd.UnixMilli(),
+				Events:      map[string]*calendar.Event{"soon": soon},
+			}
+			if tc.full {
+				for i := 1; i < eventCacheMaxEvents; i++ {
+					id := fmt.Sprintf("event_%04d", i)
+					cache.Events[id] = newEvent(id, now.Add(-3*time.Hour))
+				}
+			}
+			cacheData, err := json.Marshal(cache)
+			require.NoError(t, err)
+			syncedData, err := json.Marshal(tc.syncedAt.UnixMilli())
+			require.NoError(t, err)
+
+			key := eventCacheKey(eventCacheKeyPrefix, "user", defaultCalendarName)
+			syncedKey := eventCacheKey(eventCacheSyncedKeyPrefix, "user", defaultCalendarName)
+			var stored *eventCache
+			api := &plugintest.API{}
+			api.On("KVGet", key).Return(cacheData, nil)
+			api.On("KVGet", syncedKey).Return(syncedData, nil)
+			api.On("KVSet", key, mock.Anything).Run(func(args mock.Arguments) {
+				stored = &eventCache{}
+				require.NoError(t, json.Unmarshal(args.Get(1).([]byte), stored))
+			}).Return(nil)
+			api.On("KVSet", syncedKey, mock.Anything).Return(nil)
+			SetPluginAPI(api)
+
+			c := &client{mattermostUserID: "user"}
+			events, err := c.listCachedEvents(context.Background(), service, defaultCalendarName, now, tc.end)
+			require.NoError(t, err)
+
+			ids := []string{}
+			for _, event := range events {
+				ids = append(ids, event.Id)
+			}
+			require.Equal(t, tc.expected, ids)
+			require.Equal(t, tc.expectedRequests, requests)
+
+			if tc.expectedWindow {
+				require.GreaterOrEqual(t, stored.WindowEnd, now.Add(eventCacheLookahead).UnixMilli())
+				require.Contains(t, stored.Events, "later")
+			}
+			if tc.expectedSynced {
+				require.Equal(t, "next_token", stored.SyncToken)
+				api.AssertCalled(t, "KVSet", syncedKey, mock.Anything)
+			} else {
+				api.AssertNotCalled(t, "KVSet", syncedKey, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestEventCacheSizeLimit(t *testing.T) {
+	windowStart := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	cache := &eventCache{
+		WindowStart: windowStart.UnixMilli(),
+		WindowEnd:   windowStart.Add(30 * 24 * time.Hour).UnixMilli(),
+		Events:      map[string]*calendar.Event{},
+	}
+
+	events := []*calendar.Event{}
+	for i := 0; i < eventCacheMaxEvents+10; i++ {
+		start := windowStart.Add(time.Duration(i) * 30 * time.Minute)
+		events = append(events, &calendar.Event{
+			Id:    fmt.Sprintf("event_%04d", i),
+			Start: &calendar.EventDateTime{DateTime: start.Format(time.RFC3339)},
+			End:   &calendar.EventDateTime{DateTime: start.Add(15 * time.Minute).Format(time.RFC3339)},
+		})
+	}
+	cache.apply(events)
+
+	require.Len(t, cache.Events, eventCacheMaxEvents)
+	require.NotContains(t, cache.Events, fmt.Sprintf("event_%04d", eventCacheMaxEvents))
+	require.Equal(t, windowStart.Add(eventCacheMaxEvents*30*time.Minute).UnixMilli(), cache.WindowEnd)
+	require.False(t, cache.covers(windowStart, windowStart.Add(30*24*time.Hour)))
+}
+
+func TestNewCachedEvent(t *testing.T) {
+	event := createMinimalCalendarEvent()
+	event.Description = "Agenda: quarterly numbers\nJoin: https://zoom.us/j/123456789?pwd=abc"
+	event.Attendees = []*calendar.EventAttendee{
+		{Email: "guest@example.com", ResponseStatus: GoogleResponseStatusYes},
+		{Email: "user@example.com", Self: true, ResponseStatus: GoogleResponseStatusNone},
+	}
+	event.ConferenceData = createMultipleEntryPointsConferenceData()
+
+	cached := newCachedEvent(&event)
+	require.Equal(t, "https://zoom.us/j/123456789?pwd=abc", cached.Description)
+	require.Len(t, cached.Attendees, 1)
+	require.Equal(t, "user@example.com", cached.Attendees[0].Email)
+	require.Equal(t, convertGCalEventToConference(&event), convertGCalEventToConference(cached))
+
+	original := convertGCalEventToRemoteEvent(&event)
+	converted := convertGCalEventToRemoteEvent(cached)
+	require.Equal(t, original.Subject, converted.Subject)
+	require.Equal(t, original.Conference, converted.Conference)
+	require.Equal(t, original.Location, converted.Location)
+	require.Equal(t, original.ResponseStatus, converted.ResponseStatus)
+	require.Equal(t, original.ResponseRequested, converted.ResponseRequested)
+	require.Equal(t, original.Start, converted.Start)
+}
+
+func TestEventCacheKey(t *testing.T) {
+	key := eventCacheKey(eventCacheSyncedKeyPrefix, "user_id_with_26_characters", "c_0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef@group.calendar.google.com")
+	require.LessOrEqual(t, len(key), 150)
+	require.NotEqual(t, key, eventCacheKey(eventCacheSyncedKeyPrefix, "user_id_with_26_characters", "primary"))
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestSetSelfResponse(t *testing.T) {
+	newEvent := func() *calendar.Event {
+		evt := createMinimalCalendarEvent()
+		evt.Attendees = []*calendar.EventAttendee{
+			{Email: "gcal-plugin@mattermost.com", Organizer: true, ResponseStatus: GoogleResponseStatusYes},
+			{Email: "me@mattermost.com", Self: true, ResponseStatus: GoogleResponseStatusNone},
+			{Email: "other@mattermost.com", ResponseStatus: GoogleResponseStatusMaybe},
+		}
+		return &evt
+	}
+
+	t.Run("the response of the user is changed", func(t *testing.T) {
+		event := newEvent()
+		attendees, changed, err := setSelfResponse(event, GoogleResponseStatusNo, "on vacation")
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Len(t, attendees, 3)
+		require.Equal(t, GoogleResponseStatusNo, attendees[1].ResponseStatus)
+		require.Equal(t, "on vacation", attendees[1].Comment)
+		require.Equal(t, GoogleResponseStatusMaybe, attendees[2].ResponseStatus)
+		require.Equal(t, GoogleResponseStatusNone, event.Attendees[1].ResponseStatus, "the original event is not modified")
+	})
+
+	t.Run("the same response is not changed", func(t *testing.T) {
+		event := newEvent()
+		event.Attendees[1].ResponseStatus = GoogleResponseStatusYes
+		_, changed, err := setSelfResponse(event, GoogleResponseStatusYes, "")
+		require.NoError(t, err)
+		require.False(t, changed)
+	})
+
+	t.Run("organizers can only accept", func(t *testing.T) {
+		event := newEvent()
+		event.Organizer.Self = true
+		_, changed, err := setSelfResponse(event, GoogleResponseStatusYes, "")
+		require.NoError(t, err)
+		require.False(t, changed)
+
+		_, _, err = setSelfResponse(event, GoogleResponseStatusNo, "")
+		require.Error(t, err)
+	})
+
+	t.Run("users not in the attendee list can't respond", func(t *testing.T) {
+		event := newEvent()
+		event.Attendees = event.Attendees[:1]
+		_, _, err := setSelfResponse(event, GoogleResponseStatusYes, "")
+		require.Error(t, err)
+	})
+}
+
+func TestRescheduleEventDateTimes(t *testing.T) {
+	t.Run("timed events keep their duration", func(t *testing.T) {
+		event := createMinimalCalendarEvent()
+		start, end, err := rescheduleEventDateTimes(&event, time.Date(2023, 8, 1, 0, 35, 0, 0, time.UTC))
+		require.NoError(t, err)
+		require.Equal(t, "2023-08-01T00:35:00Z", start.DateTime)
+		require.Equal(t, "2023-08-01T00:40:00Z", end.DateTime)
+		require.Equal(t, "UTC", start.TimeZone)
+	})
+
+	t.Run("all-day events are moved to another day", func(t *testing.T) {
+		event := createMinimalCalendarEvent()
+		event.Start = &calendar.EventDateTime{Date: "2023-08-01"}
+		event.End = &calendar.EventDateTime{Date: "2023-08-03"}
+		start, end, err := rescheduleEventDateTimes(&event, time.Date(2023, 8, 10, 15, 0, 0, 0, time.UTC))
+		require.NoError(t, err)
+		require.Equal(t, "2023-08-10", start.Date)
+		require.Equal(t, "2023-08-12", end.Date)
+		require.Empty(t, start.DateTime)
+	})
+}
+
+func TestFormatEventStart(t *testing.T) {
+	event := createMinimalCalendarEvent()
+	event.Start.TimeZone = "Europe/Madrid"
+	require.Equal(t, "Tuesday, August 1 at 02:05 CEST", formatEventStart(&event))
+
+	event.Start = &calendar.EventDateTime{Date: "2023-08-01"}
+	require.Equal(t, "Tuesday, August 1", formatEventStart(&event))
+}
+
+func TestEventIDFromLink(t *testing.T) {
+	eid := base64.RawURLEncoding.EncodeToString([]byte("abc123_20230801T000500Z user@example.com"))
+
+	for name, tc := range map[string]struct {
+		link       string
+		expectedID string
+	}{
+		"event ID":         {link: "abc123", expectedID: "abc123"},
+		"event link":       {link: "https://www.google.com/calendar/event?eid=" + eid, expectedID: "abc123_20230801T000500Z"},
+		"padded event ID":  {link: "https://calendar.google.com/calendar/event?eid=" + eid + "==", expectedID: "abc123_20230801T000500Z"},
+		"link with no eid": {link: "https://calendar.google.com/calendar/r", expectedID: "https://calendar.google.com/calendar/r"},
+		"invalid eid":      {link: "https://calendar.google.com/calendar/event?eid=%21%21", expectedID: "https://calendar.google.com/calendar/event?eid=%21%21"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expectedID, EventIDFromLink(tc.link))
+		})
+	}
+}
+
+func TestConvertGCalEventToEvent(t *testing.T) {
+	event := createMinimalCalendarEvent()
+	details := convertGCalEventToEvent("primary", &event)
+	require.Equal(t, "primary", details.CalendarID)
+	require.False(t, details.IsOccurrence())
+	require.Nil(t, details.OriginalStartTime)
+
+	event.Id = "series_20230801T000500Z"
+	event.RecurringEventId = "series"
+	event.OriginalStartTime = &calendar.EventDateTime{DateTime: "2023-08-01T00:05:00Z", TimeZone: "UTC"}
+	details = convertGCalEventToEvent("primary", &event)
+	require.True(t, details.IsOccurrence())
+	require.Equal(t, "series", details.RecurringEventID)
+	require.Equal(t, "series_20230801T000500Z", details.ID)
+	require.Equal(t, "2023-08-01T00:05:00Z", details.OriginalStartTime.String())
+	require.Equal(t, "UTC", details.TimeZone)
+
+	event.Start.TimeZone = "America/New_York"
+	details = convertGCalEventToEvent("primary", &event)
+	require.Equal(t, "America/New_York", details.TimeZone)
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"google.golang.org/api/calendar/v3"
+)
+
+// Types of Google events
+const (
+	GoogleEventTypeDefault         = "default"
+	GoogleEventTypeOutOfOffice     = "outOfOffice"
+	GoogleEventTypeFocusTime       = "focusTime"
+	GoogleEventTypeWorkingLocation = "workingLocation"
+)
+
+// supportedEventTypes are the types of the events read from Google. Other types, like birthdays or events
+// from Gmail, don't affect the availability of the user.
+var supportedEventTypes = []string{
+	GoogleEventTypeDefault,
+	GoogleEventTypeOutOfOffice,
+	GoogleEventTypeFocusTime,
+	GoogleEventTypeWorkingLocation,
+}
+
+const (
+	workingLocationHomeOffice = "homeOffice"
+	workingLocationOffice     = "officeLocation"
+	workingLocationCustom     = "customLocation"
+
+	workingLocationHomeOfficeName = "Home"
+	workingLocationOfficeName     = "Office"
+)
+
+// convertGCalEventShowAs returns how the event shows the availability of the user. Events with a special
+// type get their own value so the plugin can tell them apart from regular meetings.
+func convertGCalEventShowAs(event *calendar.Event) string {
+	switch event.EventType {
+	case GoogleEventTypeOutOfOffice:
+		return RemoteEventOutOfOffice
+	case GoogleEventTypeFocusTime:
+		return RemoteEventFocusTime
+	case GoogleEventTypeWorkingLocation:
+		return RemoteEventWorkingElsewhere
+	}
+
+	if event.Transparency == GoogleEventFree {
+		return RemoteEventFree
+	}
+	return RemoteEventBusy
+}
+
+// workingLocationDisplayName returns the name of the place the user works from, or an empty string if it is unknown
+func workingLocationDisplayName(properties *calendar.EventWorkingLocationProperties) string {
+	if properties == nil {
+		return ""
+	}
+
+	switch properties.Type {
+	case workingLocationHomeOffice:
+		return workingLocationHomeOfficeName
+	case workingLocationOffice:
+		if properties.OfficeLocation != nil && properties.OfficeLocation.Label != "" {
+			return properties.OfficeLocation.Label
+		}
+		return workingLocationOfficeName
+	case workingLocationCustom:
+		if properties.CustomLocation != nil {
+			return properties.CustomLocation.Label
+		}
+	}
+
+	return ""
+}
@@ -27,7 +27,7 @@ func GetGcalProviderConfig() config.ProviderConfig {
 		BotDisplayName: ProviderGCalDisplayName,
 		Features: config.ProviderFeatures{
 			EncryptedStore:     true,
-			EventNotifications: false,
+			EventNotifications: true,
 			ForceOAuth2Consent: true,
 		},
 	}
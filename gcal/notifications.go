@@ -5,38 +5,357 @@ package gcal
 
 import (
 	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
 
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
 )
 
+// Types of the changes notified for an event
+const (
+	ChangeTypeCreated = "created"
+	ChangeTypeUpdated = "updated"
+	ChangeTypeDeleted = "deleted"
+)
+
+const (
+	notificationSyncKeyPrefix     = "gcal_notification_sync_"
+	pendingNotificationsKeyPrefix = "gcal_pending_notifications_"
+
+	// resourceStatePending is the state of the requests the plugin sends itself to deliver pending notifications
+	resourceStatePending = "gcal_pending"
+
+	// notificationSyncPageSize is the size of the pages read when getting the first sync token of a subscription
+	notificationSyncPageSize = 2500
+
+	// createdUpdatedMargin is how close the creation and last update of a new event are
+	createdUpdatedMargin = 5 * time.Second
+)
+
+// pendingNotification is a notification waiting to be delivered. The engine gets a single notification
+// per push, while a push can tell about several changed events.
+type pendingNotification struct {
+	ChangeType  string `json:"change_type"`
+	ClientState string `json:"client_state"`
+	// EventID is the changed event. It is read again when the notification is delivered, so events aren't kept
+	// in the store.
+	EventID string `json:"event_id"`
+}
+
+// GetNotificationData gets the events changed in the subscription calendar since the last notification. The first
+// one is returned, the rest is delivered through the webhook right after. Pushes without a notified change, like
+// the first one of a subscription, are returned bare.
 func (c *client) GetNotificationData(orig *remote.Notification) (*remote.Notification, error) {
-	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	ctx := context.Background()
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(c.httpClient))
 	if err != nil {
 		return nil, errors.Wrap(err, "gcal GetNotificationData, error creating service")
 	}
 
-	n := *orig
-	wh := n.Webhook.(*webhook)
+	// Subscriptions store the calendar they are watching as their resource
+	calendarID := defaultCalendarName
+	if orig.Subscription != nil && orig.Subscription.Resource != "" {
+		calendarID = orig.Subscription.Resource
+	}
 
-	cal, err := c.GetDefaultCalendar()
-	if err != nil {
-		return nil, errors.Wrap(err, "gcal GetNotificationData, error getting default calendar")
+	if orig.Event != nil && orig.Event.ID != "" {
+		return c.getPendingNotification(service, orig, calendarID)
 	}
 
-	reqBody := service.Events.Get(cal.ID, wh.Resource)
-	googleEvent, err := reqBody.Do()
+	changed, err := c.syncNotificationEvents(ctx, service, orig.SubscriptionID, calendarID)
 	if err != nil {
-		return nil, errors.Wrap(err, "gcal GetNotificationData, error fetching event data")
+		return nil, errors.Wrap(err, "gcal GetNotificationData, error getting changed events")
+	}
+	if len(changed) > 0 {
+		c.expireEventCache(calendarID)
+	}
+
+	notifications := newEventNotifications(orig, changed)
+	if len(notifications) == 0 {
+		return orig, nil
 	}
 
-	event := convertGCalEventToRemoteEvent(googleEvent)
+	return c.takeFirstNotification(orig, notifications), nil
+}
 
-	n.Event = event
+// getPendingNotification reads the event of a pending notification again. Events deleted since the notification
+// was queued are notified as deleted.
+func (c *client) getPendingNotification(service *calendar.Service, orig *remote.Notification, calendarID string) (*remote.Notification, error) {
+	n := *orig
 	n.IsBare = false
 
+	event, err := service.Events.Get(calendarID, orig.Event.ID).Do()
+	if isNotFoundError(err) {
+		n.ChangeType = ChangeTypeDeleted
+		return &n, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetNotificationData, error getting event")
+	}
+
+	if event.Status == "cancelled" {
+		n.ChangeType = ChangeTypeDeleted
+	}
+	n.Event = convertGCalEventToRemoteEvent(event)
+
 	return &n, nil
 }
+
+// takeFirstNotification returns the first notification, the rest being delivered through the webhook right after
+func (c *client) takeFirstNotification(orig *remote.Notification, notifications []*remote.Notification) *remote.Notification {
+	if len(notifications) > 1 {
+		err := queuePendingNotifications(orig.SubscriptionID, newPendingNotifications(notifications[1:]))
+		if err != nil {
+			c.Logger.Warnf("gcal: error queuing notifications. err=%v", err)
+		} else if orig.Subscription != nil {
+			go c.deliverPendingNotifications(orig.Subscription)
+		}
+	}
+
+	return notifications[0]
+}
+
+// newEventNotifications returns a notification for each changed event. Working locations, focus time and out of
+// office events aren't notified.
+func newEventNotifications(orig *remote.Notification, changed []*calendar.Event) []*remote.Notification {
+	notifications := []*remote.Notification{}
+	for _, event := range changed {
+		if event.EventType != "" && event.EventType != GoogleEventTypeDefault {
+			continue
+		}
+
+		n := *orig
+		n.ChangeType = eventChangeType(event)
+		n.Event = convertGCalEventToRemoteEvent(event)
+		n.IsBare = false
+		notifications = append(notifications, &n)
+	}
+
+	return notifications
+}
+
+// eventChangeType returns whether the event was just created, updated or deleted
+func eventChangeType(event *calendar.Event) string {
+	if event.Status == "cancelled" {
+		return ChangeTypeDeleted
+	}
+
+	created, err := time.Parse(time.RFC3339, event.Created)
+	if err != nil {
+		return ChangeTypeUpdated
+	}
+	updated, err := time.Parse(time.RFC3339, event.Updated)
+	if err != nil || updated.Sub(created) < createdUpdatedMargin {
+		return ChangeTypeCreated
+	}
+
+	return ChangeTypeUpdated
+}
+
+// syncNotificationEvents returns the events changed since the last notification of the subscription. Its sync
+// token is kept apart from the event cache one, so reading the events doesn't hide their changes from notifications.
+// Recurring events are notified once for the whole series.
+func (c *client) syncNotificationEvents(ctx context.Context, service *calendar.Service, subscriptionID, calendarID string) ([]*calendar.Event, error) {
+	key := subscriptionKey(notificationSyncKeyPrefix, subscriptionID)
+
+	syncToken := ""
+	_, err := kvGet(key, &syncToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if syncToken == "" {
+		// Nothing to compare with yet, the changes are notified from now on
+		return nil, initNotificationSync(ctx, service, subscriptionID, calendarID)
+	}
+
+	changed := []*calendar.Event{}
+	nextSyncToken := ""
+	err = service.Events.
+		List(calendarID).
+		EventTypes(GoogleEventTypeDefault).
+		SyncToken(syncToken).
+		Pages(ctx, func(page *calendar.Events) error {
+			changed = append(changed, page.Items...)
+			if page.NextSyncToken != "" {
+				nextSyncToken = page.NextSyncToken
+			}
+			return nil
+		})
+	if isGoneError(err) {
+		c.Logger.With(bot.LogContext{
+			"subscriptionID": subscriptionID,
+		}).Warnf("gcal: notification sync token expired, changes made in the meantime won't be notified.")
+		return nil, initNotificationSync(ctx, service, subscriptionID, calendarID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if nextSyncToken != "" {
+		err = kvSet(key, nextSyncToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return changed, nil
+}
+
+// initNotificationSync stores the sync token the changes of the subscription calendar are notified from
+func initNotificationSync(ctx context.Context, service *calendar.Service, subscriptionID, calendarID string) error {
+	syncToken := ""
+	err := service.Events.
+		List(calendarID).
+		EventTypes(GoogleEventTypeDefault).
+		MaxResults(notificationSyncPageSize).
+		Pages(ctx, func(page *calendar.Events) error {
+			if page.NextSyncToken != "" {
+				syncToken = page.NextSyncToken
+			}
+			return nil
+		})
+	if err != nil {
+		return errors.Wrap(err, "error getting sync token")
+	}
+
+	return kvSet(subscriptionKey(notificationSyncKeyPrefix, subscriptionID), syncToken)
+}
+
+// newPendingNotifications returns the notifications to queue for later delivery
+func newPendingNotifications(notifications []*remote.Notification) []*pendingNotification {
+	pending := []*pendingNotification{}
+	for _, n := range notifications {
+		p := &pendingNotification{
+			ChangeType:  n.ChangeType,
+			ClientState: n.ClientState,
+		}
+		if n.Event != nil {
+			p.EventID = n.Event.ID
+		}
+		pending = append(pending, p)
+	}
+	return pending
+}
+
+// queuePendingNotifications adds notifications to deliver for the subscription. Notifications are queued and taken
+// by concurrent webhooks, so the queue is only stored if it didn't change since it was read.
+func queuePendingNotifications(subscriptionID string, notifications []*pendingNotification) error {
+	key := subscriptionKey(pendingNotificationsKeyPrefix, subscriptionID)
+
+	for {
+		pending := []*pendingNotification{}
+		found, err := kvGet(key, &pending)
+		if err != nil {
+			return err
+		}
+
+		var oldValue any
+		if found {
+			oldValue = pending
+		}
+		set, err := kvCompareAndSet(key, oldValue, append(slices.Clone(pending), notifications...))
+		if err != nil || set {
+			return err
+		}
+	}
+}
+
+// takePendingNotifications returns the notifications waiting to be delivered for the subscription,
+// and removes them from the queue. Their events are read again when getting the notification data.
+func takePendingNotifications(subscriptionID string, wh *webhook) ([]*remote.Notification, error) {
+	key := subscriptionKey(pendingNotificationsKeyPrefix, subscriptionID)
+
+	var pending []*pendingNotification
+	for {
+		pending = []*pendingNotification{}
+		found, err := kvGet(key, &pending)
+		if err != nil || !found || len(pending) == 0 {
+			return nil, err
+		}
+
+		set, err := kvCompareAndSet(key, pending, []*pendingNotification{})
+		if err != nil {
+			return nil, err
+		}
+		if set {
+			break
+		}
+	}
+
+	notifications := []*remote.Notification{}
+	for _, p := range pending {
+		notifications = append(notifications, &remote.Notification{
+			SubscriptionID: subscriptionID,
+			ChangeType:     p.ChangeType,
+			ClientState:    p.ClientState,
+			Event:          &remote.Event{ID: p.EventID},
+			IsBare:         true,
+			Webhook:        wh,
+		})
+	}
+
+	return notifications, nil
+}
+
+// deliverPendingNotifications sends the plugin webhook a request to deliver the pending notifications of the
+// subscription, as if Google had sent another push
+func (c *client) deliverPendingNotifications(sub *remote.Subscription) {
+	req, err := newPendingNotificationsRequest(sub)
+	if err != nil {
+		c.Logger.Warnf("gcal: error creating pending notifications request. err=%v", err)
+		return
+	}
+
+	resp := pluginAPI.PluginHTTP(req)
+	if resp == nil {
+		c.Logger.Warnf("gcal: no response delivering pending notifications.")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		c.Logger.With(bot.LogContext{
+			"subscriptionID": sub.ID,
+			"status":         resp.StatusCode,
+		}).Warnf("gcal: error delivering pending notifications.")
+	}
+}
+
+// newPendingNotificationsRequest returns the request delivering the pending notifications of the subscription.
+// Requests between plugins go to /{plugin_id}/{path}, the notification URL without its /plugins prefix.
+func newPendingNotificationsRequest(sub *remote.Subscription) (*http.Request, error) {
+	notificationURL, err := url.Parse(sub.NotificationURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid notification URL")
+	}
+
+	i := strings.Index(notificationURL.Path, "/plugins/")
+	if i < 0 {
+		return nil, errors.Errorf("notification URL %s isn't a plugin URL", sub.NotificationURL)
+	}
+
+	path := notificationURL.Path[i+len("/plugins"):]
+	if notificationURL.RawQuery != "" {
+		path += "?" + notificationURL.RawQuery
+	}
+
+	req, err := http.NewRequest(http.MethodPost, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Goog-Channel-Id", sub.ID)
+	req.Header.Set("X-Goog-Channel-Token", sub.ClientState)
+	req.Header.Set("X-Goog-Resource-Id", sub.ResourceID)
+	req.Header.Set("X-Goog-Resource-State", resourceStatePending)
+
+	return req, nil
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+func TestEventChangeType(t *testing.T) {
+	require.Equal(t, ChangeTypeDeleted, eventChangeType(&calendar.Event{Status: "cancelled"}))
+	require.Equal(t, ChangeTypeCreated, eventChangeType(&calendar.Event{
+		Status:  "confirmed",
+		Created: "2023-08-01T09:00:00.000Z",
+		Updated: "2023-08-01T09:00:01.000Z",
+	}))
+	require.Equal(t, ChangeTypeUpdated, eventChangeType(&calendar.Event{
+		Status:  "confirmed",
+		Created: "2023-08-01T09:00:00.000Z",
+		Updated: "2023-08-02T10:00:00.000Z",
+	}))
+}
+
+func TestNewEventNotifications(t *testing.T) {
+	orig := &remote.Notification{
+		SubscriptionID: "subscription_id",
+		ClientState:    "token",
+		IsBare:         true,
+	}
+
+	invite := createMinimalCalendarEvent()
+	invite.Id = "invite"
+	workingLocation := createMinimalCalendarEvent()
+	workingLocation.Id = "working_location"
+	workingLocation.EventType = GoogleEventTypeWorkingLocation
+	cancelled := calendar.Event{Id: "cancelled", Status: "cancelled"}
+
+	notifications := newEventNotifications(orig, []*calendar.Event{&invite, &workingLocation, &cancelled})
+	require.Len(t, notifications, 2)
+
+	require.Equal(t, "invite", notifications[0].Event.ID)
+	require.Equal(t, "subscription_id", notifications[0].SubscriptionID)
+	require.Equal(t, "token", notifications[0].ClientState)
+	require.False(t, notifications[0].IsBare)
+
+	require.Equal(t, "cancelled", notifications[1].Event.ID)
+	require.Equal(t, ChangeTypeDeleted, notifications[1].ChangeType)
+	require.True(t, notifications[1].Event.IsCancelled)
+
+	require.True(t, orig.IsBare)
+}
+
+func TestNewPendingNotificationsRequest(t *testing.T) {
+	sub := &remote.Subscription{
+		ID:              "subscription_id",
+		ResourceID:      "resource_id",
+		ClientState:     "token",
+		NotificationURL: "https://mattermost.example.com/sub/plugins/com.mattermost.gcal/notification/v1/event?secret=abc",
+	}
+
+	req, err := newPendingNotificationsRequest(sub)
+	require.NoError(t, err)
+	require.Equal(t, http.MethodPost, req.Method)
+	require.Equal(t, "/com.mattermost.gcal/notification/v1/event", req.URL.Path)
+	require.Equal(t, "secret=abc", req.URL.RawQuery)
+	require.Equal(t, "subscription_id", req.Header.Get("X-Goog-Channel-Id"))
+	require.Equal(t, "token", req.Header.Get("X-Goog-Channel-Token"))
+	require.Equal(t, resourceStatePending, req.Header.Get("X-Goog-Resource-State"))
+
+	sub.NotificationURL = "https://example.com/webhook"
+	_, err = newPendingNotificationsRequest(sub)
+	require.Error(t, err)
+}
+
+func TestGetNotificationDataWithoutChange(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/calendar/v3/calendars/primary/events", r.URL.Path)
+		require.Equal(t, "sync_token", r.URL.Query().Get("syncToken"))
+		_, _ = w.Write([]byte(`{"items": [], "nextSyncToken": "next_sync_token"}`))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	syncToken, err := json.Marshal("sync_token")
+	require.NoError(t, err)
+	api := &plugintest.API{}
+	api.On("KVGet", subscriptionKey(notificationSyncKeyPrefix, "subscription_id")).Return(syncToken, nil)
+	api.On("KVSet", mock.Anything, mock.Anything).Return(nil)
+	SetPluginAPI(api)
+
+	c := &client{
+		httpClient:       &http.Client{Transport: &redirectTransport{url: serverURL}},
+		mattermostUserID: "user",
+	}
+
+	orig := &remote.Notification{
+		SubscriptionID: "subscription_id",
+		ChangeType:     ChangeTypeUpdated,
+		IsBare:         true,
+	}
+	n, err := c.GetNotificationData(orig)
+	require.NoError(t, err)
+	require.Equal(t, orig, n)
+	require.True(t, n.IsBare)
+	require.Nil(t, n.Event)
+}
+
+func TestPendingNotifications(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	key := subscriptionKey(pendingNotificationsKeyPrefix, "subscription_id")
+	queued, err := json.Marshal([]*pendingNotification{{ChangeType: ChangeTypeCreated, ClientState: "token", EventID: "event_1"}})
+	require.NoError(t, err)
+	updated, err := json.Marshal([]*pendingNotification{
+		{ChangeType: ChangeTypeCreated, ClientState: "token", EventID: "event_1"},
+		{ChangeType: ChangeTypeUpdated, ClientState: "token", EventID: "event_2"},
+	})
+	require.NoError(t, err)
+
+	t.Run("notification queued in the meantime", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", key).Return(nil, nil).Once()
+		api.On("KVCompareAndSet", key, []byte(nil), mock.Anything).Return(false, nil).Once()
+		api.On("KVGet", key).Return(queued, nil).Once()
+		api.On("KVCompareAndSet", key, queued, updated).Return(true, nil).Once()
+		SetPluginAPI(api)
+
+		event := createMinimalCalendarEvent()
+		event.Id = "event_2"
+		notifications := newEventNotifications(&remote.Notification{ClientState: "token"}, []*calendar.Event{&event})
+		notifications[0].ChangeType = ChangeTypeUpdated
+
+		require.NoError(t, queuePendingNotifications("subscription_id", newPendingNotifications(notifications)))
+		api.AssertExpectations(t)
+	})
+
+	t.Run("notifications are taken with their event only", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", key).Return(updated, nil)
+		api.On("KVCompareAndSet", key, updated, []byte(`[]`)).Return(true, nil)
+		SetPluginAPI(api)
+
+		wh := &webhook{SubscriptionID: "subscription_id"}
+		notifications, err := takePendingNotifications("subscription_id", wh)
+		require.NoError(t, err)
+		require.Len(t, notifications, 2)
+		require.Equal(t, ChangeTypeCreated, notifications[0].ChangeType)
+		require.Equal(t, "event_1", notifications[0].Event.ID)
+		require.Equal(t, "token", notifications[0].ClientState)
+		require.True(t, notifications[0].IsBare)
+		require.Equal(t, wh, notifications[0].Webhook)
+		require.Equal(t, "event_2", notifications[1].Event.ID)
+	})
+
+	t.Run("empty queue", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", key).Return([]byte(`[]`), nil)
+		SetPluginAPI(api)
+
+		notifications, err := takePendingNotifications("subscription_id", &webhook{})
+		require.NoError(t, err)
+		require.Empty(t, notifications)
+		api.AssertNotCalled(t, "KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestGetPendingNotification(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status             int
+		body               string
+		expectedChangeType string
+		expectedSubject    string
+	}{
+		"event is read again": {
+			status:             http.StatusOK,
+			body:               `{"id": "event_id", "status": "confirmed", "summary": "Release review"}`,
+			expectedChangeType: ChangeTypeUpdated,
+			expectedSubject:    "Release review",
+		},
+		"event cancelled since it was queued": {
+			status:             http.StatusOK,
+			body:               `{"id": "event_id", "status": "cancelled"}`,
+			expectedChangeType: ChangeTypeDeleted,
+		},
+		"event deleted since it was queued": {
+			status:             http.StatusNotFound,
+			body:               `{"error": {"code": 404}}`,
+			expectedChangeType: ChangeTypeDeleted,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/calendar/v3/calendars/primary/events/event_id", r.URL.Path)
+				w.WriteHeader(tc.status)
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+			serverURL, err := url.Parse(server.URL)
+			require.NoError(t, err)
+
+			c := &client{
+				httpClient:       &http.Client{Transport: &redirectTransport{url: serverURL}},
+				mattermostUserID: "user",
+			}
+
+			orig := &remote.Notification{
+				SubscriptionID: "subscription_id",
+				ChangeType:     ChangeTypeUpdated,
+				Event:          &remote.Event{ID: "event_id"},
+				IsBare:         true,
+			}
+			n, err := c.GetNotificationData(orig)
+			require.NoError(t, err)
+			require.False(t, n.IsBare)
+			require.Equal(t, tc.expectedChangeType, n.ChangeType)
+			require.Equal(t, "event_id", n.Event.ID)
+			require.Equal(t, tc.expectedSubject, n.Event.Subject)
+		})
+	}
+}
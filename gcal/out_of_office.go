@@ -0,0 +1,242 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	autoResponderKeyPrefix         = "gcal_auto_responder_"
+	autoResponderDisabledKeyPrefix = "gcal_auto_responder_disabled_"
+	autoResponderTemplateKeyPrefix = "gcal_auto_responder_template_"
+
+	// autoResponderSyncInterval is how often the out of office events of a user are checked
+	autoResponderSyncInterval = 15 * time.Minute
+
+	// autoResponderEndPlaceholder is replaced with the end of the out of office event in the templates
+	autoResponderEndPlaceholder = "{end}"
+
+	defaultAutoResponderTemplate = "I'm out of office and will be back on " + autoResponderEndPlaceholder + "."
+
+	autoResponderAllDayEndFormat = "Monday, January 2"
+	autoResponderEndFormat       = "Monday, January 2 at 3:04 PM MST"
+)
+
+// OutOfOffice are the details of an out of office event
+type OutOfOffice struct {
+	// AutoDeclineMode is how Google declines the invitations received during the event
+	AutoDeclineMode string
+	// DeclineMessage is the message sent with the declined invitations
+	DeclineMessage string
+}
+
+// autoResponder is the auto-responder enabled on a user during an out of office event
+type autoResponder struct {
+	// EventID is the out of office event the auto-responder was enabled for, empty if it isn't enabled
+	EventID string `json:"event_id"`
+	// Message is the auto-responder message set by the plugin
+	Message string `json:"message"`
+	// PreviousMessage is the auto-responder message of the user before the plugin set one
+	PreviousMessage string `json:"previous_message"`
+	// PreviousStatus is the status of the user before the plugin set them out of office
+	PreviousStatus string `json:"previous_status"`
+	// DismissedEventID is the out of office event the user disabled the auto-responder of
+	DismissedEventID string `json:"dismissed_event_id"`
+
+	syncSchedule
+}
+
+func (r *autoResponder) schedule() *syncSchedule {
+	return &r.syncSchedule
+}
+
+// autoResponderSync follows the out of office events of the users with their auto-responder
+var autoResponderSync = &periodicSync{
+	name:              "auto-responder",
+	keyPrefix:         autoResponderKeyPrefix,
+	disabledKeyPrefix: autoResponderDisabledKeyPrefix,
+	interval:          autoResponderSyncInterval,
+}
+
+// SetOutOfOfficeAutoResponder sets whether the Mattermost auto-responder of a user is enabled during their
+// Google out of office events
+func SetOutOfOfficeAutoResponder(mattermostUserID string, enabled bool) error {
+	return autoResponderSync.setEnabled(mattermostUserID, enabled)
+}
+
+// SetOutOfOfficeAutoResponderTemplate sets the auto-responder message used when an out of office event has no
+// decline message. {end} is replaced with the end of the event. An empty template restores the default one.
+func SetOutOfOfficeAutoResponderTemplate(mattermostUserID, template string) error {
+	if template == "" {
+		return kvDelete(autoResponderTemplateKeyPrefix + mattermostUserID)
+	}
+	return kvSet(autoResponderTemplateKeyPrefix+mattermostUserID, template)
+}
+
+// syncOutOfOfficeAutoResponder enables the auto-responder of the user during their out of office events,
+// and disables it when they are over
+func (c *client) syncOutOfOfficeAutoResponder() {
+	runPeriodicSync(c, autoResponderSync, func(state *autoResponder) error {
+		events, err := c.getCurrentEvents()
+		if err != nil {
+			return err
+		}
+
+		message := ""
+		event := currentOutOfOfficeEvent(events)
+		if event != nil {
+			template := ""
+			_, err = kvGet(autoResponderTemplateKeyPrefix+c.mattermostUserID, &template)
+			if err != nil {
+				c.Logger.Warnf("gcal: error loading auto-responder template. err=%v", err)
+			}
+			message = autoResponderMessage(event, template)
+		}
+
+		return updateAutoResponder(c.mattermostUserID, state, event, message)
+	})
+}
+
+// currentOutOfOfficeEvent returns the first out of office event of the list, or nil if there is none
+func currentOutOfOfficeEvent(events []*calendar.Event) *calendar.Event {
+	for _, event := range events {
+		if event.EventType == GoogleEventTypeOutOfOffice {
+			return event
+		}
+	}
+	return nil
+}
+
+// convertGCalOutOfOffice returns the out of office details of the event, or nil if it isn't an out of office event
+func convertGCalOutOfOffice(event *calendar.Event) *OutOfOffice {
+	if event.EventType != GoogleEventTypeOutOfOffice {
+		return nil
+	}
+
+	outOfOffice := &OutOfOffice{}
+	if event.OutOfOfficeProperties != nil {
+		outOfOffice.AutoDeclineMode = event.OutOfOfficeProperties.AutoDeclineMode
+		outOfOffice.DeclineMessage = event.OutOfOfficeProperties.DeclineMessage
+	}
+	return outOfOffice
+}
+
+// autoResponderMessage returns the decline message of the out of office event, or the template filled with
+// the end of the event if it has none
+func autoResponderMessage(event *calendar.Event, template string) string {
+	if outOfOffice := convertGCalOutOfOffice(event); outOfOffice != nil && outOfOffice.DeclineMessage != "" {
+		return outOfOffice.DeclineMessage
+	}
+
+	if template == "" {
+		template = defaultAutoResponderTemplate
+	}
+	return strings.ReplaceAll(template, autoResponderEndPlaceholder, formatEventEnd(event.End))
+}
+
+// formatEventEnd formats the end of an event in its own time zone
+func formatEventEnd(end *calendar.EventDateTime) string {
+	t, err := parseEventDateTime(end)
+	if err != nil {
+		return ""
+	}
+
+	if end.Date != "" {
+		return t.Format(autoResponderAllDayEndFormat)
+	}
+
+	if loc, err := time.LoadLocation(end.TimeZone); end.TimeZone != "" && err == nil {
+		t = t.In(loc)
+	}
+	return t.Format(autoResponderEndFormat)
+}
+
+// updateAutoResponder enables the auto-responder of the user with the message while the event lasts, and
+// disables it once the event is over. An auto-responder enabled by the user is left alone.
+func updateAutoResponder(mattermostUserID string, state *autoResponder, event *calendar.Event, message string) error {
+	user, appErr := pluginAPI.GetUser(mattermostUserID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "error getting user")
+	}
+	if user.NotifyProps == nil {
+		user.NotifyProps = model.StringMap{}
+	}
+
+	active := user.NotifyProps[model.AutoResponderActiveNotifyProp] == "true"
+	current := user.NotifyProps[model.AutoResponderMessageNotifyProp]
+
+	if state.EventID != "" && (!active || current != state.Message) {
+		// The user changed or disabled the auto-responder themselves
+		*state = autoResponder{DismissedEventID: state.EventID}
+	}
+
+	if event == nil {
+		state.DismissedEventID = ""
+		if state.EventID == "" {
+			return nil
+		}
+		return disableAutoResponder(user, state)
+	}
+
+	if state.EventID == "" && (active || event.Id == state.DismissedEventID) {
+		return nil
+	}
+	if state.EventID == event.Id && state.Message == message {
+		return nil
+	}
+
+	if state.EventID == "" {
+		state.PreviousMessage = current
+		status, appErr := pluginAPI.GetUserStatus(mattermostUserID)
+		if appErr == nil {
+			state.PreviousStatus = status.Status
+		}
+	}
+
+	user.NotifyProps[model.AutoResponderActiveNotifyProp] = "true"
+	user.NotifyProps[model.AutoResponderMessageNotifyProp] = message
+	_, appErr = pluginAPI.UpdateUser(user)
+	if appErr != nil {
+		return errors.Wrap(appErr, "error enabling auto-responder")
+	}
+
+	_, appErr = pluginAPI.UpdateUserStatus(mattermostUserID, model.StatusOutOfOffice)
+	if appErr != nil {
+		return errors.Wrap(appErr, "error setting out of office status")
+	}
+
+	state.EventID = event.Id
+	state.Message = message
+	state.DismissedEventID = ""
+
+	return nil
+}
+
+// disableAutoResponder disables the auto-responder of the user and restores their previous message and status
+func disableAutoResponder(user *model.User, state *autoResponder) error {
+	user.NotifyProps[model.AutoResponderActiveNotifyProp] = "false"
+	user.NotifyProps[model.AutoResponderMessageNotifyProp] = state.PreviousMessage
+	_, appErr := pluginAPI.UpdateUser(user)
+	if appErr != nil {
+		return errors.Wrap(appErr, "error disabling auto-responder")
+	}
+
+	previousStatus := state.PreviousStatus
+	if previousStatus == "" || previousStatus == model.StatusOutOfOffice {
+		previousStatus = model.StatusOnline
+	}
+	_, appErr = pluginAPI.UpdateUserStatus(user.Id, previousStatus)
+	if appErr != nil {
+		return errors.Wrap(appErr, "error restoring status")
+	}
+
+	*state = autoResponder{}
+	return nil
+}
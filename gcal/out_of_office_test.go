@@ -0,0 +1,153 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func createOutOfOfficeEvent(declineMessage string) *calendar.Event {
+	return &calendar.Event{
+		Id:        "ooo_event",
+		EventType: GoogleEventTypeOutOfOffice,
+		Start:     &calendar.EventDateTime{Date: "2023-08-01"},
+		End:       &calendar.EventDateTime{Date: "2023-08-07"},
+		OutOfOfficeProperties: &calendar.EventOutOfOfficeProperties{
+			AutoDeclineMode: "declineAllConflictingInvitations",
+			DeclineMessage:  declineMessage,
+		},
+	}
+}
+
+func TestAutoResponderMessage(t *testing.T) {
+	tcs := []struct {
+		Name     string
+		Event    *calendar.Event
+		Template string
+		Expected string
+	}{
+		{
+			Name:     "decline message",
+			Event:    createOutOfOfficeEvent("On vacation, ask @jdoe"),
+			Expected: "On vacation, ask @jdoe",
+		},
+		{
+			Name:     "default template",
+			Event:    createOutOfOfficeEvent(""),
+			Expected: "I'm out of office and will be back on Monday, August 7.",
+		},
+		{
+			Name:     "custom template",
+			Event:    createOutOfOfficeEvent(""),
+			Template: "Away until {end}",
+			Expected: "Away until Monday, August 7",
+		},
+		{
+			Name: "timed event",
+			Event: &calendar.Event{
+				EventType: GoogleEventTypeOutOfOffice,
+				End:       &calendar.EventDateTime{DateTime: "2023-08-07T13:00:00Z", TimeZone: "Europe/Berlin"},
+			},
+			Template: "Away until {end}",
+			Expected: "Away until Monday, August 7 at 3:00 PM CEST",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Expected, autoResponderMessage(tc.Event, tc.Template))
+		})
+	}
+}
+
+func TestConvertGCalOutOfOffice(t *testing.T) {
+	require.Nil(t, convertGCalOutOfOffice(&calendar.Event{EventType: GoogleEventTypeDefault}))
+	require.Equal(t, &OutOfOffice{
+		AutoDeclineMode: "declineAllConflictingInvitations",
+		DeclineMessage:  "On vacation",
+	}, convertGCalOutOfOffice(createOutOfOfficeEvent("On vacation")))
+}
+
+func TestUpdateAutoResponder(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	newUser := func(active bool, message string) *model.User {
+		user := &model.User{Id: "user_id", NotifyProps: model.StringMap{}}
+		if active {
+			user.NotifyProps[model.AutoResponderActiveNotifyProp] = "true"
+		}
+		user.NotifyProps[model.AutoResponderMessageNotifyProp] = message
+		return user
+	}
+	event := createOutOfOfficeEvent("On vacation")
+
+	t.Run("auto-responder is enabled during the event", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", "user_id").Return(newUser(false, "Old message"), nil)
+		api.On("GetUserStatus", "user_id").Return(&model.Status{Status: model.StatusDnd}, nil)
+		api.On("UpdateUser", mock.MatchedBy(func(user *model.User) bool {
+			return user.NotifyProps[model.AutoResponderActiveNotifyProp] == "true" &&
+				user.NotifyProps[model.AutoResponderMessageNotifyProp] == "On vacation"
+		})).Return(nil, nil)
+		api.On("UpdateUserStatus", "user_id", model.StatusOutOfOffice).Return(nil, nil)
+		SetPluginAPI(api)
+
+		state := &autoResponder{}
+		require.NoError(t, updateAutoResponder("user_id", state, event, "On vacation"))
+		require.Equal(t, "ooo_event", state.EventID)
+		require.Equal(t, "Old message", state.PreviousMessage)
+		require.Equal(t, model.StatusDnd, state.PreviousStatus)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("auto-responder is disabled after the event", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", "user_id").Return(newUser(true, "On vacation"), nil)
+		api.On("UpdateUser", mock.MatchedBy(func(user *model.User) bool {
+			return user.NotifyProps[model.AutoResponderActiveNotifyProp] == "false" &&
+				user.NotifyProps[model.AutoResponderMessageNotifyProp] == "Old message"
+		})).Return(nil, nil)
+		api.On("UpdateUserStatus", "user_id", model.StatusDnd).Return(nil, nil)
+		SetPluginAPI(api)
+
+		state := &autoResponder{
+			EventID:         "ooo_event",
+			Message:         "On vacation",
+			PreviousMessage: "Old message",
+			PreviousStatus:  model.StatusDnd,
+		}
+		require.NoError(t, updateAutoResponder("user_id", state, nil, ""))
+		require.Equal(t, autoResponder{}, *state)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("auto-responder enabled by the user is left alone", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", "user_id").Return(newUser(true, "My own message"), nil)
+		SetPluginAPI(api)
+
+		state := &autoResponder{}
+		require.NoError(t, updateAutoResponder("user_id", state, event, "On vacation"))
+		require.Empty(t, state.EventID)
+		api.AssertNotCalled(t, "UpdateUser", mock.Anything)
+	})
+
+	t.Run("auto-responder disabled by the user during the event stays disabled", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", "user_id").Return(newUser(false, "On vacation"), nil)
+		SetPluginAPI(api)
+
+		state := &autoResponder{EventID: "ooo_event", Message: "On vacation"}
+		require.NoError(t, updateAutoResponder("user_id", state, event, "On vacation"))
+		require.Empty(t, state.EventID)
+		require.Equal(t, "ooo_event", state.DismissedEventID)
+		api.AssertNotCalled(t, "UpdateUser", mock.Anything)
+	})
+}
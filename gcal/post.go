@@ -0,0 +1,38 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// postToChannel posts a message to a channel as the plugin bot
+func (c *client) postToChannel(channelID, message string) error {
+	if pluginAPI == nil {
+		return errPluginAPINotAvailable
+	}
+	if c.conf == nil {
+		return errors.New("plugin configuration is not available")
+	}
+
+	botUserID, err := pluginAPI.EnsureBotUser(&model.Bot{
+		Username:    c.conf.Provider.BotUsername,
+		DisplayName: c.conf.Provider.BotDisplayName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "error getting bot user")
+	}
+
+	_, appErr := pluginAPI.CreatePost(&model.Post{
+		UserId:    botUserID,
+		ChannelId: channelID,
+		Message:   message,
+	})
+	if appErr != nil {
+		return errors.Wrap(appErr, "error creating post")
+	}
+
+	return nil
+}
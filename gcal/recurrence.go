@@ -0,0 +1,521 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+// Frequencies an event can repeat with
+const (
+	RecurrenceDaily   = "DAILY"
+	RecurrenceWeekly  = "WEEKLY"
+	RecurrenceMonthly = "MONTHLY"
+	RecurrenceYearly  = "YEARLY"
+)
+
+const (
+	rruleDateTimeFormat = "20060102T150405Z"
+	rruleDateFormat     = "20060102"
+)
+
+// byDayRegexp matches a weekday, optionally preceded by its occurrence within the month, like MO or -1FR
+var byDayRegexp = regexp.MustCompile(`^([+-]?[1-5])?(MO|TU|WE|TH|FR|SA|SU)$`)
+
+// Recurrence describes how an event repeats, as a subset of the RFC 5545 recurrence rules supported by Google
+type Recurrence struct {
+	// Frequency is one of RecurrenceDaily, RecurrenceWeekly, RecurrenceMonthly or RecurrenceYearly
+	Frequency string
+
+	// Interval is the number of frequency periods between occurrences, 1 if not set
+	Interval int
+
+	// ByDay are the weekdays the event happens on, like MO or TU. For monthly events, they can be preceded by
+	// the occurrence within the month, like 1MO for the first Monday or -1FR for the last Friday.
+	ByDay []string
+
+	// ByMonthDay are the days of the month the event happens on, for monthly events
+	ByMonthDay []int
+
+	// Count is the number of occurrences of the event. Count and Until can't be set at the same time.
+	Count int
+
+	// Until is the last time the event can happen
+	Until time.Time
+
+	// Exceptions are the start times of the occurrences that don't happen
+	Exceptions []time.Time
+}
+
+// Validate checks the recurrence can be sent to Google
+func (r *Recurrence) Validate() error {
+	switch r.Frequency {
+	case RecurrenceDaily, RecurrenceWeekly, RecurrenceMonthly, RecurrenceYearly:
+	default:
+		return errors.Errorf("invalid recurrence frequency %q", r.Frequency)
+	}
+
+	if r.Interval < 0 || r.Count < 0 {
+		return errors.New("recurrence interval and count can't be negative")
+	}
+
+	if r.Count > 0 && !r.Until.IsZero() {
+		return errors.New("recurrence count and until can't be set at the same time")
+	}
+
+	for _, day := range r.ByDay {
+		matches := byDayRegexp.FindStringSubmatch(day)
+		if matches == nil {
+			return errors.Errorf("invalid recurrence weekday %q", day)
+		}
+		if matches[1] != "" && r.Frequency != RecurrenceMonthly && r.Frequency != RecurrenceYearly {
+			return errors.Errorf("recurrence weekday %q is only valid for monthly or yearly events", day)
+		}
+	}
+
+	for _, day := range r.ByMonthDay {
+		if day == 0 || day < -31 || day > 31 {
+			return errors.Errorf("invalid recurrence month day %d", day)
+		}
+	}
+
+	return nil
+}
+
+// Rules returns the RRULE and EXDATE lines of the recurrence, as expected in the Recurrence field of Google events
+func (r *Recurrence) Rules(isAllDay bool) ([]string, error) {
+	err := r.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []string{"FREQ=" + r.Frequency}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		parts = append(parts, "BYDAY="+strings.Join(r.ByDay, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, 0, len(r.ByMonthDay))
+		for _, day := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(day))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+formatRecurrenceTime(r.Until, isAllDay))
+	}
+
+	rules := []string{"RRULE:" + strings.Join(parts, ";")}
+	for _, exception := range r.Exceptions {
+		if isAllDay {
+			rules = append(rules, "EXDATE;VALUE=DATE:"+formatRecurrenceTime(exception, true))
+		} else {
+			rules = append(rules, "EXDATE:"+formatRecurrenceTime(exception, false))
+		}
+	}
+
+	return rules, nil
+}
+
+// formatRecurrenceTime formats a time as expected by the RFC 5545 recurrence rules, which use
+// dates for all-day events and UTC times otherwise
+func formatRecurrenceTime(t time.Time, isAllDay bool) string {
+	if isAllDay {
+		return t.Format(rruleDateFormat)
+	}
+	return t.UTC().Format(rruleDateTimeFormat)
+}
+
+// Scopes of a change made to an occurrence of a recurring event
+const (
+	// RecurrenceScopeThis only changes the occurrence
+	RecurrenceScopeThis = "this"
+
+	// RecurrenceScopeFollowing changes the occurrence and the following ones, splitting the series in two
+	RecurrenceScopeFollowing = "following"
+
+	// RecurrenceScopeAll changes every occurrence of the series
+	RecurrenceScopeAll = "all"
+)
+
+// UpdateRecurringEvent changes an occurrence of a recurring event of the user, along with the rest of the
+// occurrences in the given scope. Start and end changes are applied to every occurrence in the scope by
+// the same amount of time.
+func (c *client) UpdateRecurringEvent(eventID string, in *remote.Event, scope string, notifyAttendees bool) (*remote.Event, error) {
+	calendarID, instance, err := c.getEvent(eventID)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal UpdateRecurringEvent")
+	}
+
+	result, err := c.patchEventInScope(calendarID, instance, convertRemoteEventToGcalEvent(in), scope, notifyAttendees)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal UpdateRecurringEvent")
+	}
+
+	return convertGCalEventToRemoteEvent(result), nil
+}
+
+// RescheduleRecurringEvent moves an occurrence of a recurring event of the user to a new start time, along with
+// the rest of the occurrences in the given scope, keeping their duration
+func (c *client) RescheduleRecurringEvent(eventID string, start time.Time, scope string, notifyAttendees bool) (*remote.Event, error) {
+	calendarID, instance, err := c.getEvent(eventID)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal RescheduleRecurringEvent")
+	}
+
+	newStart, newEnd, err := rescheduleEventDateTimes(instance, start)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal RescheduleRecurringEvent")
+	}
+
+	result, err := c.patchEventInScope(calendarID, instance, &calendar.Event{Start: newStart, End: newEnd}, scope, notifyAttendees)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal RescheduleRecurringEvent")
+	}
+
+	return convertGCalEventToRemoteEvent(result), nil
+}
+
+// patchEventInScope applies the changes to the occurrence and to the rest of the occurrences in the scope
+func (c *client) patchEventInScope(calendarID string, instance, patch *calendar.Event, scope string, notifyAttendees bool) (*calendar.Event, error) {
+	defer c.expireEventCache(calendarID)
+
+	switch {
+	case instance.RecurringEventId == "", scope == RecurrenceScopeThis:
+		return c.patchEvent(calendarID, instance, patch, notifyAttendees)
+	case scope == RecurrenceScopeAll:
+		return c.updateEventSeries(calendarID, instance, patch, notifyAttendees)
+	case scope == RecurrenceScopeFollowing:
+		return c.splitEventSeries(calendarID, instance, patch, notifyAttendees)
+	}
+
+	return nil, errors.Errorf("invalid recurrence scope %q", scope)
+}
+
+// CancelRecurringEvent cancels an occurrence of a recurring event of the user, along with the rest of the
+// occurrences in the given scope, optionally posting a notice to the channel the calendar is linked to
+func (c *client) CancelRecurringEvent(eventID, scope string, notifyAttendees, notifyChannel bool) error {
+	calendarID, instance, err := c.getEvent(eventID)
+	if err != nil {
+		return errors.Wrap(err, "gcal CancelRecurringEvent")
+	}
+
+	if instance.RecurringEventId == "" || scope == RecurrenceScopeThis {
+		return c.CancelEvent(eventID, notifyAttendees, notifyChannel)
+	}
+	defer c.expireEventCache(calendarID)
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return errors.Wrap(err, "gcal CancelRecurringEvent, error creating service")
+	}
+
+	sendUpdates := sendUpdatesNone
+	if notifyAttendees {
+		sendUpdates = sendUpdatesAll
+	}
+
+	var notice string
+	switch scope {
+	case RecurrenceScopeAll:
+		err = service.Events.Delete(calendarID, instance.RecurringEventId).SendUpdates(sendUpdates).Do()
+		if err != nil && !isNotFoundError(err) {
+			return errors.Wrap(err, "gcal CancelRecurringEvent, error deleting series")
+		}
+		notice = fmt.Sprintf(seriesCancelledMessage, instance.Summary)
+
+	case RecurrenceScopeFollowing:
+		series, err := service.Events.Get(calendarID, instance.RecurringEventId).Do()
+		if err != nil {
+			return errors.Wrap(err, "gcal CancelRecurringEvent, error getting series")
+		}
+
+		rules, err := truncateRecurrenceRules(series, instance.OriginalStartTime)
+		if err != nil {
+			return errors.Wrap(err, "gcal CancelRecurringEvent")
+		}
+
+		_, err = c.patchEvent(calendarID, series, &calendar.Event{Recurrence: rules}, notifyAttendees)
+		if err != nil {
+			return errors.Wrap(err, "gcal CancelRecurringEvent, error ending series")
+		}
+		notice = fmt.Sprintf(followingCancelledMessage, instance.Summary, formatEventStart(instance))
+
+	default:
+		return errors.Errorf("gcal CancelRecurringEvent, invalid recurrence scope %q", scope)
+	}
+
+	// The organizer is "self" when the event belongs to the calendar it was read from
+	if !notifyChannel || instance.Organizer == nil || !instance.Organizer.Self {
+		return nil
+	}
+
+	err = c.postToCalendarChannel(calendarID, notice)
+	if err != nil {
+		return errors.Wrap(err, "gcal CancelRecurringEvent")
+	}
+
+	return nil
+}
+
+// updateEventSeries applies the changes made to an occurrence to the whole series
+func (c *client) updateEventSeries(calendarID string, instance, patch *calendar.Event, notifyAttendees bool) (*calendar.Event, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating service")
+	}
+
+	series, err := service.Events.Get(calendarID, instance.RecurringEventId).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting series")
+	}
+
+	patch.Start, err = shiftSeriesDateTime(series.Start, instance.Start, patch.Start)
+	if err != nil {
+		return nil, err
+	}
+	patch.End, err = shiftSeriesDateTime(series.End, instance.End, patch.End)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.patchEvent(calendarID, series, patch, notifyAttendees)
+}
+
+// splitEventSeries ends the series before the occurrence, and starts a new series with the changes from it
+func (c *client) splitEventSeries(calendarID string, instance, patch *calendar.Event, notifyAttendees bool) (*calendar.Event, error) {
+	ctx := context.Background()
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating service")
+	}
+
+	series, err := service.Events.Get(calendarID, instance.RecurringEventId).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting series")
+	}
+
+	isOrganizer := series.Organizer != nil && series.Organizer.Self
+	if calendarID == defaultCalendarName && !isOrganizer {
+		return nil, errors.New("only the organizer can split the series")
+	}
+
+	splitAt, err := parseEventDateTime(instance.OriginalStartTime)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing occurrence start")
+	}
+
+	// Occurrences before the split count towards the number of occurrences of the original series
+	previous := 0
+	if recurrenceRuleCount(series.Recurrence) > 0 {
+		err = service.Events.Instances(calendarID, series.Id).ShowDeleted(true).Pages(ctx, func(page *calendar.Events) error {
+			for _, item := range page.Items {
+				start, err := parseEventDateTime(item.OriginalStartTime)
+				if err == nil && start.Before(splitAt) {
+					previous++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "error listing occurrences")
+		}
+	}
+
+	truncated, err := truncateRecurrenceRules(series, instance.OriginalStartTime)
+	if err != nil {
+		return nil, err
+	}
+
+	following := &calendar.Event{
+		Summary:      series.Summary,
+		Description:  series.Description,
+		Location:     series.Location,
+		Attendees:    copyAttendees(series.Attendees),
+		Transparency: series.Transparency,
+		Visibility:   series.Visibility,
+		Reminders:    series.Reminders,
+		Start:        instance.Start,
+		End:          instance.End,
+		Recurrence:   remainingRecurrenceRules(series.Recurrence, previous),
+	}
+	if patch.Summary != "" {
+		following.Summary = patch.Summary
+	}
+	if patch.Description != "" {
+		following.Description = patch.Description
+	}
+	if patch.Location != "" {
+		following.Location = patch.Location
+	}
+	if patch.Attendees != nil {
+		following.Attendees = patch.Attendees
+	}
+	if patch.Start != nil {
+		following.Start = patch.Start
+	}
+	if patch.End != nil {
+		following.End = patch.End
+	}
+
+	sendUpdates := sendUpdatesNone
+	if notifyAttendees {
+		sendUpdates = sendUpdatesAll
+	}
+
+	// The new series is created first, so no occurrence is lost if anything fails
+	result, err := service.Events.Insert(calendarID, following).SendUpdates(sendUpdates).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating the following series")
+	}
+
+	_, err = c.patchEvent(calendarID, series, &calendar.Event{Recurrence: truncated}, notifyAttendees)
+	if err != nil {
+		_ = service.Events.Delete(calendarID, result.Id).SendUpdates(sendUpdatesNone).Do()
+		return nil, errors.Wrap(err, "error ending the original series")
+	}
+
+	return result, nil
+}
+
+// copyAttendees returns the attendees of an event without their responses, for a new event
+func copyAttendees(attendees []*calendar.EventAttendee) []*calendar.EventAttendee {
+	out := make([]*calendar.EventAttendee, 0, len(attendees))
+	for _, attendee := range attendees {
+		out = append(out, &calendar.EventAttendee{
+			Email:       attendee.Email,
+			DisplayName: attendee.DisplayName,
+			Optional:    attendee.Optional,
+		})
+	}
+	return out
+}
+
+// shiftSeriesDateTime moves the start or end of a series by the same amount of time the occurrence was moved
+func shiftSeriesDateTime(seriesDT, instanceDT, newInstanceDT *calendar.EventDateTime) (*calendar.EventDateTime, error) {
+	if newInstanceDT == nil {
+		return nil, nil
+	}
+
+	instanceTime, err := parseEventDateTime(instanceDT)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing occurrence time")
+	}
+	newInstanceTime, err := parseEventDateTime(newInstanceDT)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing new occurrence time")
+	}
+	seriesTime, err := parseEventDateTime(seriesDT)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing series time")
+	}
+
+	shifted := seriesTime.Add(newInstanceTime.Sub(instanceTime))
+	if seriesDT.Date != "" {
+		return &calendar.EventDateTime{Date: shifted.Format(time.DateOnly)}, nil
+	}
+
+	return &calendar.EventDateTime{DateTime: shifted.Format(time.RFC3339), TimeZone: seriesDT.TimeZone}, nil
+}
+
+// parseEventDateTime returns the time of a Google event start or end. All-day dates are taken at midnight UTC,
+// which is only right to compare them with each other.
+func parseEventDateTime(dt *calendar.EventDateTime) (time.Time, error) {
+	return parseEventDateTimeIn(dt, time.UTC)
+}
+
+// parseEventDateTimeIn returns the time of a Google event start or end. All-day dates are taken at midnight in the
+// given location, which should be the time zone of the calendar of the event.
+func parseEventDateTimeIn(dt *calendar.EventDateTime, loc *time.Location) (time.Time, error) {
+	if dt == nil {
+		return time.Time{}, errors.New("missing date")
+	}
+	if dt.Date != "" {
+		return time.ParseInLocation(time.DateOnly, dt.Date, loc)
+	}
+	return time.Parse(time.RFC3339, dt.DateTime)
+}
+
+// truncateRecurrenceRules returns the recurrence rules of a series ending right before the given occurrence
+func truncateRecurrenceRules(series *calendar.Event, occurrenceStart *calendar.EventDateTime) ([]string, error) {
+	splitAt, err := parseEventDateTime(occurrenceStart)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing occurrence start")
+	}
+
+	isAllDay := series.Start != nil && series.Start.Date != ""
+	until := splitAt.Add(-time.Second)
+	if isAllDay {
+		until = splitAt.AddDate(0, 0, -1)
+	}
+
+	return setRecurrenceRuleEnd(series.Recurrence, "UNTIL="+formatRecurrenceTime(until, isAllDay)), nil
+}
+
+// remainingRecurrenceRules returns the recurrence rules for the rest of a series, given the number of
+// occurrences that already happened
+func remainingRecurrenceRules(rules []string, previous int) []string {
+	count := recurrenceRuleCount(rules)
+	if count == 0 {
+		return rules
+	}
+
+	return setRecurrenceRuleEnd(rules, fmt.Sprintf("COUNT=%d", max(count-previous, 1)))
+}
+
+// recurrenceRuleCount returns the number of occurrences set in the RRULE, or 0 if it is not limited by count
+func recurrenceRuleCount(rules []string) int {
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule, "RRULE:") {
+			continue
+		}
+
+		for _, part := range strings.Split(strings.TrimPrefix(rule, "RRULE:"), ";") {
+			if value, ok := strings.CutPrefix(part, "COUNT="); ok {
+				count, _ := strconv.Atoi(value)
+				return count
+			}
+		}
+	}
+
+	return 0
+}
+
+// setRecurrenceRuleEnd replaces the COUNT and UNTIL parts of the RRULE with the given one
+func setRecurrenceRuleEnd(rules []string, end string) []string {
+	out := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule, "RRULE:") {
+			out = append(out, rule)
+			continue
+		}
+
+		parts := []string{}
+		for _, part := range strings.Split(strings.TrimPrefix(rule, "RRULE:"), ";") {
+			if !strings.HasPrefix(part, "COUNT=") && !strings.HasPrefix(part, "UNTIL=") {
+				parts = append(parts, part)
+			}
+		}
+		parts = append(parts, end)
+		out = append(out, "RRULE:"+strings.Join(parts, ";"))
+	}
+
+	return out
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestRecurrenceRules(t *testing.T) {
+	for _, tc := range []struct {
+		Name       string
+		Recurrence Recurrence
+		IsAllDay   bool
+		Expected   []string
+		Error      string
+	}{
+		{
+			Name:       "daily",
+			Recurrence: Recurrence{Frequency: RecurrenceDaily},
+			Expected:   []string{"RRULE:FREQ=DAILY"},
+		},
+		{
+			Name: "weekly on weekdays with count",
+			Recurrence: Recurrence{
+				Frequency: RecurrenceWeekly,
+				ByDay:     []string{"MO", "TU", "WE", "TH", "FR"},
+				Count:     10,
+			},
+			Expected: []string{"RRULE:FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR;COUNT=10"},
+		},
+		{
+			Name: "every other week until a date with exceptions",
+			Recurrence: Recurrence{
+				Frequency:  RecurrenceWeekly,
+				Interval:   2,
+				ByDay:      []string{"TH"},
+				Until:      time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC),
+				Exceptions: []time.Time{time.Date(2023, 8, 10, 11, 0, 0, 0, time.FixedZone("CEST", 2*60*60))},
+			},
+			Expected: []string{
+				"RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TH;UNTIL=20231231T235959Z",
+				"EXDATE:20230810T090000Z",
+			},
+		},
+		{
+			Name: "monthly on the last friday of all-day events",
+			Recurrence: Recurrence{
+				Frequency:  RecurrenceMonthly,
+				ByDay:      []string{"-1FR"},
+				Until:      time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC),
+				Exceptions: []time.Time{time.Date(2023, 8, 25, 0, 0, 0, 0, time.UTC)},
+			},
+			IsAllDay: true,
+			Expected: []string{
+				"RRULE:FREQ=MONTHLY;BYDAY=-1FR;UNTIL=20231231",
+				"EXDATE;VALUE=DATE:20230825",
+			},
+		},
+		{
+			Name:       "monthly on days of the month",
+			Recurrence: Recurrence{Frequency: RecurrenceMonthly, ByMonthDay: []int{1, 15}},
+			Expected:   []string{"RRULE:FREQ=MONTHLY;BYMONTHDAY=1,15"},
+		},
+		{
+			Name:       "invalid frequency",
+			Recurrence: Recurrence{Frequency: "HOURLY"},
+			Error:      `invalid recurrence frequency "HOURLY"`,
+		},
+		{
+			Name:       "count and until",
+			Recurrence: Recurrence{Frequency: RecurrenceDaily, Count: 3, Until: time.Now()},
+			Error:      "recurrence count and until can't be set at the same time",
+		},
+		{
+			Name:       "invalid weekday",
+			Recurrence: Recurrence{Frequency: RecurrenceWeekly, ByDay: []string{"MONDAY"}},
+			Error:      `invalid recurrence weekday "MONDAY"`,
+		},
+		{
+			Name:       "weekday occurrence for weekly events",
+			Recurrence: Recurrence{Frequency: RecurrenceWeekly, ByDay: []string{"1MO"}},
+			Error:      `recurrence weekday "1MO" is only valid for monthly or yearly events`,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			rules, err := tc.Recurrence.Rules(tc.IsAllDay)
+			if tc.Error != "" {
+				require.EqualError(t, err, tc.Error)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.Expected, rules)
+		})
+	}
+}
+
+func TestTruncateRecurrenceRules(t *testing.T) {
+	series := createMinimalCalendarEvent()
+	series.Recurrence = []string{"RRULE:FREQ=WEEKLY;BYDAY=TU;COUNT=10", "EXDATE:20230808T000500Z"}
+
+	rules, err := truncateRecurrenceRules(&series, &calendar.EventDateTime{DateTime: "2023-08-15T02:05:00+02:00"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"RRULE:FREQ=WEEKLY;BYDAY=TU;UNTIL=20230815T000459Z", "EXDATE:20230808T000500Z"}, rules)
+
+	series.Start = &calendar.EventDateTime{Date: "2023-08-01"}
+	rules, err = truncateRecurrenceRules(&series, &calendar.EventDateTime{Date: "2023-08-15"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"RRULE:FREQ=WEEKLY;BYDAY=TU;UNTIL=20230814", "EXDATE:20230808T000500Z"}, rules)
+}
+
+func TestRemainingRecurrenceRules(t *testing.T) {
+	require.Equal(t,
+		[]string{"RRULE:FREQ=DAILY;COUNT=7"},
+		remainingRecurrenceRules([]string{"RRULE:FREQ=DAILY;COUNT=10"}, 3),
+	)
+	require.Equal(t,
+		[]string{"RRULE:FREQ=DAILY;UNTIL=20231231T000000Z"},
+		remainingRecurrenceRules([]string{"RRULE:FREQ=DAILY;UNTIL=20231231T000000Z"}, 3),
+	)
+}
+
+func TestShiftSeriesDateTime(t *testing.T) {
+	series := &calendar.EventDateTime{DateTime: "2023-08-01T09:00:00+02:00", TimeZone: "Europe/Madrid"}
+	instance := &calendar.EventDateTime{DateTime: "2023-08-15T09:00:00+02:00", TimeZone: "Europe/Madrid"}
+	moved := &calendar.EventDateTime{DateTime: "2023-08-15T09:30:00+02:00"}
+
+	shifted, err := shiftSeriesDateTime(series, instance, moved)
+	require.NoError(t, err)
+	require.Equal(t, "2023-08-01T09:30:00+02:00", shifted.DateTime)
+	require.Equal(t, "Europe/Madrid", shifted.TimeZone)
+
+	shifted, err = shiftSeriesDateTime(series, instance, nil)
+	require.NoError(t, err)
+	require.Nil(t, shifted)
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+const (
+	reminderMethodPopup = "popup"
+
+	// maxReminderOverrides and maxReminderMinutes are the limits Google puts on the reminders of an event
+	maxReminderOverrides = 5
+	maxReminderMinutes   = 40320
+
+	followGoogleRemindersKeyPrefix = "gcal_follow_google_reminders_"
+)
+
+// SetFollowGoogleReminders sets whether the Mattermost reminders of a user follow the reminders set on
+// each Google event, instead of the default reminder time
+func SetFollowGoogleReminders(mattermostUserID string, follow bool) error {
+	if !follow {
+		return kvDelete(followGoogleRemindersKeyPrefix + mattermostUserID)
+	}
+	return kvSet(followGoogleRemindersKeyPrefix+mattermostUserID, follow)
+}
+
+// loadFollowGoogleReminders returns true if the user opted to follow the Google reminders of their events
+func loadFollowGoogleReminders(mattermostUserID string) (bool, error) {
+	follow := false
+	_, err := kvGet(followGoogleRemindersKeyPrefix+mattermostUserID, &follow)
+	return follow, err
+}
+
+// applyReminderPreference clears the reminder offsets of the events unless the user opted to follow them,
+// so the default reminder time is used
+func (c *client) applyReminderPreference(events ...*remote.Event) {
+	follow, err := loadFollowGoogleReminders(c.mattermostUserID)
+	if err != nil {
+		c.Logger.Warnf("gcal: error loading reminder preference. err=%v", err)
+	}
+	if follow {
+		return
+	}
+
+	for _, event := range events {
+		event.ReminderMinutesBeforeStart = 0
+	}
+}
+
+// resolveDefaultReminders replaces the reminders of an event read on its own with the calendar defaults when it uses
+// them, as Google only returns the defaults along with event lists. Nothing is resolved for users who don't follow
+// the Google reminders, as theirs are cleared anyway.
+func (c *client) resolveDefaultReminders(calendarID string, event *calendar.Event) {
+	if event.Reminders == nil || !event.Reminders.UseDefault {
+		return
+	}
+
+	follow, err := loadFollowGoogleReminders(c.mattermostUserID)
+	if err != nil || !follow {
+		return
+	}
+
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		c.Logger.Warnf("gcal: error creating service. err=%v", err)
+		return
+	}
+
+	entry, err := service.CalendarList.Get(calendarID).Do()
+	if err != nil {
+		c.Logger.Warnf("gcal: error getting calendar default reminders. err=%v", err)
+		return
+	}
+
+	applyDefaultReminders([]*calendar.Event{event}, entry.DefaultReminders)
+}
+
+// applyDefaultReminders replaces the reminders of the events using the calendar defaults with the defaults
+// themselves, as the events only say they use them
+func applyDefaultReminders(events []*calendar.Event, defaults []*calendar.EventReminder) {
+	for _, event := range events {
+		if event.Reminders != nil && event.Reminders.UseDefault {
+			event.Reminders = &calendar.EventReminders{
+				Overrides: defaults,
+			}
+		}
+	}
+}
+
+// reminderMinutesBeforeStart returns the offset of the popup reminder closest to the start of the event,
+// or 0 if the event has no popup reminder
+func reminderMinutesBeforeStart(reminders *calendar.EventReminders) int {
+	if reminders == nil {
+		return 0
+	}
+
+	minutes := 0
+	found := false
+	for _, reminder := range reminders.Overrides {
+		if reminder == nil || reminder.Method != reminderMethodPopup {
+			continue
+		}
+
+		if !found || int(reminder.Minutes) < minutes {
+			minutes = int(reminder.Minutes)
+			found = true
+		}
+	}
+
+	return minutes
+}
+
+// newReminderOverrides returns reminders that replace the calendar defaults with popups at the given offsets
+func newReminderOverrides(minutes []int) (*calendar.EventReminders, error) {
+	if len(minutes) > maxReminderOverrides {
+		return nil, errors.Errorf("an event can't have more than %d reminders", maxReminderOverrides)
+	}
+
+	reminders := &calendar.EventReminders{
+		Overrides: []*calendar.EventReminder{},
+		// Without it, the event would keep using the calendar defaults
+		ForceSendFields: []string{"UseDefault"},
+	}
+
+	for _, m := range minutes {
+		if m < 0 || m > maxReminderMinutes {
+			return nil, errors.Errorf("invalid reminder offset of %d minutes", m)
+		}
+
+		reminders.Overrides = append(reminders.Overrides, &calendar.EventReminder{
+			Method:  reminderMethodPopup,
+			Minutes: int64(m),
+			// Reminders at the start of the event have no offset
+			ForceSendFields: []string{"Minutes"},
+		})
+	}
+
+	return reminders, nil
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+// redirectTransport sends the requests to the Google APIs to a test server
+type redirectTransport struct {
+	url *url.URL
+}
+
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = t.url.Scheme
+	req.URL.Host = t.url.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestReminderMinutesBeforeStart(t *testing.T) {
+	tcs := []struct {
+		Name      string
+		Reminders *calendar.EventReminders
+		Expected  int
+	}{
+		{
+			Name:      "no reminders",
+			Reminders: nil,
+			Expected:  0,
+		},
+		{
+			Name: "closest popup is used",
+			Reminders: &calendar.EventReminders{
+				Overrides: []*calendar.EventReminder{
+					{Method: reminderMethodPopup, Minutes: 30},
+					{Method: "email", Minutes: 5},
+					{Method: reminderMethodPopup, Minutes: 10},
+				},
+			},
+			Expected: 10,
+		},
+		{
+			Name: "only email reminders",
+			Reminders: &calendar.EventReminders{
+				Overrides: []*calendar.EventReminder{
+					{Method: "email", Minutes: 60},
+				},
+			},
+			Expected: 0,
+		},
+		{
+			Name: "defaults not resolved",
+			Reminders: &calendar.EventReminders{
+				UseDefault: true,
+			},
+			Expected: 0,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Expected, reminderMinutesBeforeStart(tc.Reminders))
+		})
+	}
+}
+
+func TestApplyDefaultReminders(t *testing.T) {
+	defaults := []*calendar.EventReminder{{Method: reminderMethodPopup, Minutes: 15}}
+	overrides := &calendar.EventReminders{
+		Overrides: []*calendar.EventReminder{{Method: reminderMethodPopup, Minutes: 2}},
+	}
+	events := []*calendar.Event{
+		{Id: "default", Reminders: &calendar.EventReminders{UseDefault: true}},
+		{Id: "overrides", Reminders: overrides},
+		{Id: "none"},
+	}
+
+	applyDefaultReminders(events, defaults)
+
+	require.Equal(t, 15, reminderMinutesBeforeStart(events[0].Reminders))
+	require.Equal(t, overrides, events[1].Reminders)
+	require.Nil(t, events[2].Reminders)
+}
+
+func TestResolveDefaultReminders(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		require.Equal(t, "/calendar/v3/users/me/calendarList/team@group.calendar.google.com", r.URL.Path)
+		_, _ = w.Write([]byte(`{"defaultReminders": [{"method": "popup", "minutes": 15}]}`))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	c := &client{
+		httpClient:       &http.Client{Transport: &redirectTransport{url: serverURL}},
+		mattermostUserID: "user",
+	}
+
+	for name, tc := range map[string]struct {
+		follow           bool
+		reminders        *calendar.EventReminders
+		expectedMinutes  int
+		expectedRequests int
+	}{
+		"default reminders": {
+			follow:           true,
+			reminders:        &calendar.EventReminders{UseDefault: true},
+			expectedMinutes:  15,
+			expectedRequests: 1,
+		},
+		"overridden reminders": {
+			follow: true,
+			reminders: &calendar.EventReminders{
+				Overrides: []*calendar.EventReminder{{Method: reminderMethodPopup, Minutes: 2}},
+			},
+			expectedMinutes: 2,
+		},
+		"not following the google reminders": {
+			reminders: &calendar.EventReminders{UseDefault: true},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			requests = 0
+			follow := []byte(nil)
+			if tc.follow {
+				follow = []byte("true")
+			}
+			api := &plugintest.API{}
+			api.On("KVGet", followGoogleRemindersKeyPrefix+"user").Return(follow, nil)
+			SetPluginAPI(api)
+
+			event := &calendar.Event{Reminders: tc.reminders}
+			c.resolveDefaultReminders("team@group.calendar.google.com", event)
+			require.Equal(t, tc.expectedMinutes, reminderMinutesBeforeStart(event.Reminders))
+			require.Equal(t, tc.expectedRequests, requests)
+		})
+	}
+}
+
+func TestNewReminderOverrides(t *testing.T) {
+	reminders, err := newReminderOverrides([]int{0, 10})
+	require.NoError(t, err)
+	require.False(t, reminders.UseDefault)
+	require.Contains(t, reminders.ForceSendFields, "UseDefault")
+	require.Len(t, reminders.Overrides, 2)
+	require.Equal(t, int64(0), reminders.Overrides[0].Minutes)
+	require.Equal(t, reminderMethodPopup, reminders.Overrides[1].Method)
+
+	reminders, err = newReminderOverrides([]int{})
+	require.NoError(t, err)
+	require.Empty(t, reminders.Overrides)
+
+	_, err = newReminderOverrides([]int{-1})
+	require.Error(t, err)
+
+	_, err = newReminderOverrides([]int{1, 2, 3, 4, 5, 6})
+	require.Error(t, err)
+}
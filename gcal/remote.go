@@ -47,13 +47,13 @@ func (r *impl) MakeUserClient(ctx context.Context, oauthToken *oauth2.Token, mat
 	}
 
 	httpClient := config.Client(ctx, token)
-	c := &client{
-		conf:       r.conf,
-		ctx:        ctx,
-		httpClient: httpClient,
-		Logger:     r.logger,
+	return &client{
+		conf:             r.conf,
+		ctx:              ctx,
+		httpClient:       httpClient,
+		mattermostUserID: mattermostUserID,
+		Logger:           r.logger,
 	}
-	return c
 }
 
 // MakeSuperuserClient creates a new client used for app-only permissions.
@@ -0,0 +1,152 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// PluginAPI is the part of the Mattermost plugin API used by the provider to keep the state
+// the calendar engine doesn't know about
+type PluginAPI interface {
+	KVGet(key string) ([]byte, *model.AppError)
+	KVSet(key string, value []byte) *model.AppError
+	KVCompareAndSet(key string, oldValue, newValue []byte) (bool, *model.AppError)
+	KVDelete(key string) *model.AppError
+	GetChannel(channelID string) (*model.Channel, *model.AppError)
+	GetChannelMembers(channelID string, page, perPage int) (model.ChannelMembers, *model.AppError)
+	EnsureBotUser(bot *model.Bot) (string, error)
+	CreatePost(post *model.Post) (*model.Post, *model.AppError)
+	PluginHTTP(request *http.Request) *http.Response
+	GetUser(userID string) (*model.User, *model.AppError)
+	UpdateUser(user *model.User) (*model.User, *model.AppError)
+	GetUserStatus(userID string) (*model.Status, *model.AppError)
+	UpdateUserStatus(userID, status string) (*model.Status, *model.AppError)
+	UpdateUserCustomStatus(userID string, customStatus *model.CustomStatus) *model.AppError
+	RemoveUserCustomStatus(userID string) *model.AppError
+}
+
+// pluginAPI is only available once the plugin has been activated
+var pluginAPI PluginAPI
+
+// SetPluginAPI sets the plugin API used by the provider. It must be called when the plugin is activated.
+func SetPluginAPI(api PluginAPI) {
+	pluginAPI = api
+}
+
+const (
+	googleEmailKeyPrefix              = "gcal_google_email_"
+	channelCalendarKeyPrefix          = "gcal_channel_calendar_"
+	channelCalendarNeedsSyncKeyPrefix = "gcal_channel_calendar_sync_"
+	calendarChannelKeyPrefix          = "gcal_calendar_channel_"
+	ownedChannelCalendarsKeyPrefix    = "gcal_owned_channel_calendars_"
+)
+
+var errPluginAPINotAvailable = errors.New("plugin API is not available")
+
+// kvGet loads the JSON value stored under the key. It returns false if there is no value for the key.
+func kvGet(key string, out any) (bool, error) {
+	if pluginAPI == nil {
+		return false, errPluginAPINotAvailable
+	}
+
+	data, appErr := pluginAPI.KVGet(key)
+	if appErr != nil {
+		return false, errors.Wrapf(appErr, "error loading key %s", key)
+	}
+	if data == nil {
+		return false, nil
+	}
+
+	err := json.Unmarshal(data, out)
+	if err != nil {
+		return false, errors.Wrapf(err, "error decoding key %s", key)
+	}
+
+	return true, nil
+}
+
+// kvSet stores the value under the key as JSON
+func kvSet(key string, in any) error {
+	if pluginAPI == nil {
+		return errPluginAPINotAvailable
+	}
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		return errors.Wrapf(err, "error encoding key %s", key)
+	}
+
+	appErr := pluginAPI.KVSet(key, data)
+	if appErr != nil {
+		return errors.Wrapf(appErr, "error storing key %s", key)
+	}
+
+	return nil
+}
+
+// kvCompareAndSet stores the value under the key as JSON if the key still holds the old value, or holds nothing
+// when the old value is nil. It returns false if the key was changed in the meantime.
+func kvCompareAndSet(key string, oldValue, newValue any) (bool, error) {
+	if pluginAPI == nil {
+		return false, errPluginAPINotAvailable
+	}
+
+	var oldData []byte
+	if oldValue != nil {
+		var err error
+		oldData, err = json.Marshal(oldValue)
+		if err != nil {
+			return false, errors.Wrapf(err, "error encoding key %s", key)
+		}
+	}
+	newData, err := json.Marshal(newValue)
+	if err != nil {
+		return false, errors.Wrapf(err, "error encoding key %s", key)
+	}
+
+	set, appErr := pluginAPI.KVCompareAndSet(key, oldData, newData)
+	if appErr != nil {
+		return false, errors.Wrapf(appErr, "error storing key %s", key)
+	}
+
+	return set, nil
+}
+
+func kvDelete(key string) error {
+	if pluginAPI == nil {
+		return errPluginAPINotAvailable
+	}
+
+	appErr := pluginAPI.KVDelete(key)
+	if appErr != nil {
+		return errors.Wrapf(appErr, "error deleting key %s", key)
+	}
+
+	return nil
+}
+
+// storeGoogleEmail remembers the email of the Google account a Mattermost user connected
+func storeGoogleEmail(mattermostUserID, email string) error {
+	return kvSet(googleEmailKeyPrefix+mattermostUserID, email)
+}
+
+// loadGoogleEmail returns the email of the Google account a Mattermost user connected, or an empty string
+// if it is not known
+func loadGoogleEmail(mattermostUserID string) (string, error) {
+	email := ""
+	_, err := kvGet(googleEmailKeyPrefix+mattermostUserID, &email)
+	return email, err
+}
+
+// loadCalendarChannelID returns the channel a calendar is linked to, or an empty string if it isn't linked to any
+func loadCalendarChannelID(calendarID string) (string, error) {
+	channelID := ""
+	_, err := kvGet(calendarChannelKeyPrefix+calendarID, &channelID)
+	return channelID, err
+}
@@ -5,7 +5,12 @@ package gcal
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"slices"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -16,7 +21,17 @@ import (
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
 )
 
-const subscribeTTL = 7 * 24 * time.Hour // 7 days
+// DefaultSubscriptionTTL is how long subscriptions last when no other TTL is configured
+const DefaultSubscriptionTTL = 7 * 24 * time.Hour // 7 days
+
+// minSubscriptionTTL is how long subscriptions must last at least. The engine looks for subscriptions to renew at
+// least once a day, and their renewal is recommended during their last day, so they must last two days for one
+// run to always fall in that day before they expire.
+const minSubscriptionTTL = 2 * renewRecommendedBefore
+
+// subscriptionTTL is how long new subscriptions are asked to last, or zero for the default TTL. It is set when the
+// configuration changes, while subscriptions are being created.
+var subscriptionTTL atomic.Int64
 
 const (
 	defaultCalendarName    = "primary"
@@ -24,9 +39,161 @@ const (
 	subscriptionSuffix     = "_calendar_event_notifications_"
 )
 
-// CreateMySubscription creates a subscription for the user's calendar
+const (
+	subscriptionKeyPrefix        = "gcal_subscription_"
+	subscriptionMessageKeyPrefix = "gcal_subscription_message_"
+	lastNotificationKeyPrefix    = "gcal_last_notification_"
+
+	// subscriptionRegistryKey holds the IDs of the subscriptions of all the users
+	subscriptionRegistryKey = "gcal_subscription_registry"
+)
+
+// Health of the subscriptions
+const (
+	SubscriptionHealthy  = "healthy"
+	SubscriptionExpiring = "expiring"
+	// SubscriptionDead subscriptions don't deliver notifications anymore, as they expired or were renewed
+	SubscriptionDead = "dead"
+)
+
+// subscriptionRecord is what the plugin keeps about a subscription to verify its webhooks. Google has no way to
+// list channels, so the records are listed from the subscription registry.
+type subscriptionRecord struct {
+	ID         string `json:"id"`
+	ResourceID string `json:"resource_id"`
+	CalendarID string `json:"calendar_id"`
+	// ClientStateHash is the hash of the token Google sends with every webhook of the subscription
+	ClientStateHash  string `json:"client_state_hash"`
+	CreatorID        string `json:"creator_id"`
+	MattermostUserID string `json:"mattermost_user_id"`
+	// Expiration is the time the subscription expires at, in milliseconds
+	Expiration int64 `json:"expiration"`
+	// ReplacedBy is the subscription renewing this one. Its webhooks are dropped until it is stopped.
+	ReplacedBy string `json:"replaced_by"`
+}
+
+// SetSubscriptionTTL sets how long the subscriptions created from now on last, and returns the TTL in use.
+// A TTL too short for the subscriptions to be renewed in time is raised to the shortest one that is.
+func SetSubscriptionTTL(ttl time.Duration) time.Duration {
+	ttl = max(ttl, minSubscriptionTTL)
+	subscriptionTTL.Store(int64(ttl))
+	return ttl
+}
+
+// getSubscriptionTTL returns how long new subscriptions are asked to last. Google can end them earlier.
+func getSubscriptionTTL() time.Duration {
+	if ttl := subscriptionTTL.Load(); ttl > 0 {
+		return time.Duration(ttl)
+	}
+	return DefaultSubscriptionTTL
+}
+
+func storeSubscriptionRecord(record *subscriptionRecord) error {
+	return kvSet(subscriptionKey(subscriptionKeyPrefix, record.ID), record)
+}
+
+// loadSubscriptionRecord returns the subscription with the given ID, or nil if there is none
+func loadSubscriptionRecord(subscriptionID string) (*subscriptionRecord, error) {
+	record := &subscriptionRecord{}
+	found, err := kvGet(subscriptionKey(subscriptionKeyPrefix, subscriptionID), record)
+	if err != nil || !found {
+		return nil, err
+	}
+	return record, nil
+}
+
+// listSubscriptionRecords returns the subscriptions of all the users
+func listSubscriptionRecords() ([]*subscriptionRecord, error) {
+	subscriptionIDs := []string{}
+	_, err := kvGet(subscriptionRegistryKey, &subscriptionIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*subscriptionRecord{}
+	for _, subscriptionID := range subscriptionIDs {
+		record, err := loadSubscriptionRecord(subscriptionID)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			records = append(records, record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].MattermostUserID == records[j].MattermostUserID {
+			return records[i].Expiration < records[j].Expiration
+		}
+		return records[i].MattermostUserID < records[j].MattermostUserID
+	})
+
+	return records, nil
+}
+
+// updateSubscriptionRegistry adds a subscription to the registry, or removes it. Subscriptions of different users
+// are created and deleted concurrently, so the registry is only stored if it didn't change since it was read.
+func updateSubscriptionRegistry(subscriptionID string, registered bool) error {
+	for {
+		subscriptionIDs := []string{}
+		found, err := kvGet(subscriptionRegistryKey, &subscriptionIDs)
+		if err != nil {
+			return err
+		}
+
+		updated := slices.DeleteFunc(slices.Clone(subscriptionIDs), func(id string) bool {
+			return id == subscriptionID
+		})
+		if registered {
+			updated = append(updated, subscriptionID)
+		}
+		if slices.Equal(updated, subscriptionIDs) {
+			return nil
+		}
+
+		var oldValue any
+		if found {
+			oldValue = subscriptionIDs
+		}
+		set, err := kvCompareAndSet(subscriptionRegistryKey, oldValue, updated)
+		if err != nil || set {
+			return err
+		}
+	}
+}
+
+// subscriptionHealth returns whether the subscription delivers notifications, and for how long
+func subscriptionHealth(record *subscriptionRecord, now time.Time) string {
+	if record.ReplacedBy != "" || (record.Expiration > 0 && !time.UnixMilli(record.Expiration).After(now)) {
+		return SubscriptionDead
+	}
+	if renewRecommended(record, now) {
+		return SubscriptionExpiring
+	}
+	return SubscriptionHealthy
+}
+
+// subscriptionKey returns the key of some state of a subscription. Google channel IDs start with the remote user ID
+// and are too long for the keys of the KV store, so they are hashed.
+func subscriptionKey(prefix, subscriptionID string) string {
+	hash := sha256.Sum256([]byte(subscriptionID))
+	return prefix + hex.EncodeToString(hash[:16])
+}
+
+func hashClientState(clientState string) string {
+	hash := sha256.Sum256([]byte(clientState))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateMySubscription creates a subscription for the user's primary calendar
 func (c *client) CreateMySubscription(notificationURL, remoteUserID string) (*remote.Subscription, error) {
-	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	return c.createSubscription(defaultCalendarName, notificationURL, remoteUserID)
+}
+
+// createSubscription creates a subscription for the given calendar of the user
+func (c *client) createSubscription(calendarID, notificationURL, remoteUserID string) (*remote.Subscription, error) {
+	ctx := context.Background()
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(c.httpClient))
 	if err != nil {
 		return nil, errors.Wrap(err, "gcal CreateMySubscription, error creating service")
 	}
@@ -37,27 +204,53 @@ func (c *client) CreateMySubscription(notificationURL, remoteUserID string) (*re
 		Type:    googleSubscriptionType,
 		Address: notificationURL,
 		Params: map[string]string{
-			"ttl": fmt.Sprintf("%d", int64(subscribeTTL.Seconds())),
+			"ttl": fmt.Sprintf("%d", int64(getSubscriptionTTL().Seconds())),
 		},
 	}
 
-	createSubscriptionRequest := service.Events.Watch(defaultCalendarName, reqBody).EventTypes("default")
+	createSubscriptionRequest := service.Events.Watch(calendarID, reqBody).EventTypes(supportedEventTypes...)
 	googleSubscription, err := createSubscriptionRequest.Do()
 	if err != nil {
 		return nil, errors.Wrap(err, "gcal CreateMySubscription, error creating subscription")
 	}
 
+	expiresAt := channelExpiration(googleSubscription, time.Now())
 	sub := &remote.Subscription{
 		ID:         googleSubscription.Id,
 		ResourceID: googleSubscription.ResourceId,
-		Resource:   defaultCalendarName,
+		Resource:   calendarID,
 		// ChangeType:         "created,updated,deleted",
 		NotificationURL:    notificationURL,
-		ExpirationDateTime: time.Now().Add(time.Second * time.Duration(googleSubscription.Expiration)).Format(time.RFC3339),
+		ExpirationDateTime: expiresAt.Format(time.RFC3339),
 		ClientState:        reqBody.Token,
 		CreatorID:          remoteUserID,
 	}
 
+	err = storeSubscriptionRecord(&subscriptionRecord{
+		ID:               sub.ID,
+		ResourceID:       sub.ResourceID,
+		CalendarID:       calendarID,
+		ClientStateHash:  hashClientState(sub.ClientState),
+		CreatorID:        remoteUserID,
+		MattermostUserID: c.mattermostUserID,
+		Expiration:       expiresAt.UnixMilli(),
+	})
+	if err == nil {
+		err = updateSubscriptionRegistry(sub.ID, true)
+	}
+	if err != nil {
+		// Webhooks of unknown subscriptions are rejected
+		_ = service.Channels.Stop(&calendar.Channel{Id: sub.ID, ResourceId: sub.ResourceID}).Do()
+		_ = kvDelete(subscriptionKey(subscriptionKeyPrefix, sub.ID))
+		return nil, errors.Wrap(err, "gcal CreateMySubscription, error storing subscription")
+	}
+
+	// Changes are notified from the creation of the subscription
+	err = initNotificationSync(ctx, service, sub.ID, calendarID)
+	if err != nil {
+		c.Logger.Warnf("gcal: error starting notification sync. err=%v", err)
+	}
+
 	c.Logger.With(bot.LogContext{
 		"subscriptionID": sub.ID,
 		"resource":       sub.Resource,
@@ -68,6 +261,14 @@ func (c *client) CreateMySubscription(notificationURL, remoteUserID string) (*re
 	return sub, nil
 }
 
+// channelExpiration returns the time a Google channel expires at. Its expiration is a timestamp in milliseconds.
+func channelExpiration(channel *calendar.Channel, now time.Time) time.Time {
+	if channel.Expiration == 0 {
+		return now.Add(getSubscriptionTTL())
+	}
+	return time.UnixMilli(channel.Expiration)
+}
+
 // DeleteSubscription deletes a subscription
 func (c *client) DeleteSubscription(sub *remote.Subscription) error {
 	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
@@ -80,9 +281,28 @@ func (c *client) DeleteSubscription(sub *remote.Subscription) error {
 		ResourceId: sub.ResourceID,
 	})
 	err = stopRequest.Do()
-	if err != nil {
+	if err != nil && !isNotFoundError(err) {
 		return errors.Wrap(err, "gcal DeleteSubscription, error from google response")
 	}
+	// A channel Google doesn't know anymore is already stopped, its state is removed all the same
+
+	for _, key := range []string{
+		subscriptionKey(subscriptionKeyPrefix, sub.ID),
+		subscriptionKey(subscriptionMessageKeyPrefix, sub.ID),
+		subscriptionKey(notificationSyncKeyPrefix, sub.ID),
+		subscriptionKey(pendingNotificationsKeyPrefix, sub.ID),
+		subscriptionKey(lastNotificationKeyPrefix, sub.ID),
+	} {
+		err = kvDelete(key)
+		if err != nil && err != errPluginAPINotAvailable {
+			c.Logger.Warnf("gcal: error deleting subscription state. err=%v", err)
+		}
+	}
+
+	err = updateSubscriptionRegistry(sub.ID, false)
+	if err != nil && err != errPluginAPINotAvailable {
+		c.Logger.Warnf("gcal: error removing subscription from the registry. err=%v", err)
+	}
 
 	c.Logger.With(bot.LogContext{
 		"subscriptionID": sub.ID,
@@ -91,16 +311,30 @@ func (c *client) DeleteSubscription(sub *remote.Subscription) error {
 	return nil
 }
 
-// RenewSubscription deletes the old subscription and creates a new one in order to "renew" it
+// RenewSubscription creates a new subscription for the calendar of the old one before stopping it, so no change
+// is missed in between
 func (c *client) RenewSubscription(notificationURL, remoteUserID string, oldSub *remote.Subscription) (*remote.Subscription, error) {
-	err := c.DeleteSubscription(oldSub)
+	calendarID := defaultCalendarName
+	if oldSub.Resource != "" {
+		calendarID = oldSub.Resource
+	}
+
+	sub, err := c.createSubscription(calendarID, notificationURL, remoteUserID)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal RenewSubscription, error creating subscription")
+	}
+
+	err = handOverSubscription(oldSub.ID, sub.ID)
 	if err != nil {
-		return nil, errors.Wrap(err, "gcal RenewSubscription, error deleting subscription")
+		c.Logger.Warnf("gcal: error handing over subscription. err=%v", err)
 	}
 
-	sub, err := c.CreateMySubscription(notificationURL, remoteUserID)
+	err = c.DeleteSubscription(oldSub)
 	if err != nil {
-		return nil, errors.Wrap(err, "gcal RenewSubscription, error deleting subscription")
+		// The old subscription expires by itself, its webhooks are dropped in the meantime
+		c.Logger.With(bot.LogContext{
+			"subscriptionID": oldSub.ID,
+		}).Warnf("gcal: error stopping renewed subscription. err=%v", err)
 	}
 
 	c.Debugf("gcal: renewed subscription.")
@@ -108,7 +342,111 @@ func (c *client) RenewSubscription(notificationURL, remoteUserID string, oldSub
 	return sub, nil
 }
 
-// ListSubscriptions lists all subscriptions
+// handOverSubscription makes the new subscription notify the changes from where the old one stopped, along
+// with its pending notifications. Webhooks of both subscriptions tell about the same changes, so the ones
+// of the old subscription are dropped from now on.
+func handOverSubscription(oldSubscriptionID, newSubscriptionID string) error {
+	record, err := loadSubscriptionRecord(oldSubscriptionID)
+	if err != nil || record == nil {
+		return err
+	}
+
+	record.ReplacedBy = newSubscriptionID
+	err = storeSubscriptionRecord(record)
+	if err != nil {
+		return err
+	}
+
+	syncToken := ""
+	_, err = kvGet(subscriptionKey(notificationSyncKeyPrefix, oldSubscriptionID), &syncToken)
+	if err != nil {
+		return err
+	}
+	if syncToken != "" {
+		err = kvSet(subscriptionKey(notificationSyncKeyPrefix, newSubscriptionID), syncToken)
+		if err != nil {
+			return err
+		}
+	}
+
+	pending := []*pendingNotification{}
+	_, err = kvGet(subscriptionKey(pendingNotificationsKeyPrefix, oldSubscriptionID), &pending)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	return queuePendingNotifications(newSubscriptionID, pending)
+}
+
+// SubscriptionStatus describes a subscription of a user
+type SubscriptionStatus struct {
+	ID               string
+	ResourceID       string
+	CalendarID       string
+	MattermostUserID string
+	RemoteUserID     string
+	ExpiresAt        time.Time
+	// LastNotificationAt is zero until Google notifies a change
+	LastNotificationAt time.Time
+	Health             string
+}
+
+// ListSubscriptionStatuses returns the subscriptions of all the users with their health, so admins can tell
+// which users don't get notified of their event changes
+func ListSubscriptionStatuses() ([]*SubscriptionStatus, error) {
+	records, err := listSubscriptionRecords()
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing subscriptions")
+	}
+
+	now := time.Now()
+	statuses := []*SubscriptionStatus{}
+	for _, record := range records {
+		status := &SubscriptionStatus{
+			ID:               record.ID,
+			ResourceID:       record.ResourceID,
+			CalendarID:       record.CalendarID,
+			MattermostUserID: record.MattermostUserID,
+			RemoteUserID:     record.CreatorID,
+			ExpiresAt:        time.UnixMilli(record.Expiration),
+			Health:           subscriptionHealth(record, now),
+		}
+
+		var lastNotificationAt int64
+		_, err = kvGet(subscriptionKey(lastNotificationKeyPrefix, record.ID), &lastNotificationAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "error loading last notification time")
+		}
+		if lastNotificationAt > 0 {
+			status.LastNotificationAt = time.UnixMilli(lastNotificationAt)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// ListSubscriptions lists the subscriptions of the user from the ones kept by the plugin
 func (c *client) ListSubscriptions() ([]*remote.Subscription, error) {
-	return nil, errors.New("gcal ListSubscriptions not implemented. only used for debug command")
+	records, err := listSubscriptionRecords()
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal ListSubscriptions, error listing subscriptions")
+	}
+
+	subs := []*remote.Subscription{}
+	for _, record := range records {
+		if record.MattermostUserID != c.mattermostUserID {
+			continue
+		}
+		subs = append(subs, &remote.Subscription{
+			ID:                 record.ID,
+			ResourceID:         record.ResourceID,
+			Resource:           record.CalendarID,
+			ExpirationDateTime: time.UnixMilli(record.Expiration).Format(time.RFC3339),
+			CreatorID:          record.CreatorID,
+		})
+	}
+
+	return subs, nil
 }
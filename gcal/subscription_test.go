@@ -0,0 +1,268 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestChannelExpiration(t *testing.T) {
+	now := time.Date(2023, time.August, 1, 9, 0, 0, 0, time.UTC)
+	expiration := now.Add(3 * 24 * time.Hour)
+
+	require.Equal(t, expiration.UnixMilli(), channelExpiration(&calendar.Channel{Expiration: expiration.UnixMilli()}, now).UnixMilli())
+	require.Equal(t, now.Add(getSubscriptionTTL()), channelExpiration(&calendar.Channel{}, now))
+}
+
+func TestSetSubscriptionTTL(t *testing.T) {
+	defer subscriptionTTL.Store(0)
+
+	require.Equal(t, DefaultSubscriptionTTL, getSubscriptionTTL())
+
+	require.Equal(t, 48*time.Hour, SetSubscriptionTTL(12*time.Hour))
+	require.Equal(t, 48*time.Hour, getSubscriptionTTL())
+
+	require.Equal(t, 72*time.Hour, SetSubscriptionTTL(72*time.Hour))
+	require.Equal(t, 72*time.Hour, getSubscriptionTTL())
+
+	// Renewal is recommended during the last day of the subscriptions
+	now := time.Now()
+	require.False(t, renewRecommended(&subscriptionRecord{Expiration: now.Add(25 * time.Hour).UnixMilli()}, now))
+	require.True(t, renewRecommended(&subscriptionRecord{Expiration: now.Add(23 * time.Hour).UnixMilli()}, now))
+}
+
+func TestSubscriptionKey(t *testing.T) {
+	subscriptionID := "maximilian.smith@example.com" + subscriptionSuffix + newRandomString()
+	require.Len(t, subscriptionID, 186)
+
+	for _, prefix := range []string{
+		subscriptionKeyPrefix,
+		subscriptionMessageKeyPrefix,
+		lastNotificationKeyPrefix,
+		notificationSyncKeyPrefix,
+		pendingNotificationsKeyPrefix,
+	} {
+		key := subscriptionKey(prefix, subscriptionID)
+		require.LessOrEqual(t, len(key), model.KeyValueKeyMaxRunes)
+		require.NotEqual(t, key, subscriptionKey(prefix, subscriptionID+"_renewed"))
+	}
+}
+
+func TestHandOverSubscription(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	record, err := json.Marshal(&subscriptionRecord{ID: "old_id", ResourceID: "resource_id"})
+	require.NoError(t, err)
+	syncToken, err := json.Marshal("sync_token")
+	require.NoError(t, err)
+	pending, err := json.Marshal([]*pendingNotification{{ChangeType: ChangeTypeUpdated, EventID: "event_id"}})
+	require.NoError(t, err)
+
+	api := &plugintest.API{}
+	api.On("KVGet", subscriptionKey(subscriptionKeyPrefix, "old_id")).Return(record, nil)
+	api.On("KVGet", subscriptionKey(notificationSyncKeyPrefix, "old_id")).Return(syncToken, nil)
+	api.On("KVGet", subscriptionKey(pendingNotificationsKeyPrefix, "old_id")).Return(pending, nil)
+	api.On("KVGet", subscriptionKey(pendingNotificationsKeyPrefix, "new_id")).Return(nil, nil)
+	api.On("KVSet", mock.Anything, mock.Anything).Return(nil)
+	api.On("KVCompareAndSet", subscriptionKey(pendingNotificationsKeyPrefix, "new_id"), []byte(nil), pending).Return(true, nil)
+	SetPluginAPI(api)
+
+	require.NoError(t, handOverSubscription("old_id", "new_id"))
+
+	replaced, err := json.Marshal(&subscriptionRecord{ID: "old_id", ResourceID: "resource_id", ReplacedBy: "new_id"})
+	require.NoError(t, err)
+	api.AssertCalled(t, "KVSet", subscriptionKey(subscriptionKeyPrefix, "old_id"), replaced)
+	api.AssertCalled(t, "KVSet", subscriptionKey(notificationSyncKeyPrefix, "new_id"), syncToken)
+	api.AssertExpectations(t)
+}
+
+func TestSubscriptionHealth(t *testing.T) {
+	now := time.Now()
+
+	require.Equal(t, SubscriptionHealthy, subscriptionHealth(&subscriptionRecord{Expiration: now.Add(3 * 24 * time.Hour).UnixMilli()}, now))
+	require.Equal(t, SubscriptionExpiring, subscriptionHealth(&subscriptionRecord{Expiration: now.Add(time.Hour).UnixMilli()}, now))
+	require.Equal(t, SubscriptionDead, subscriptionHealth(&subscriptionRecord{Expiration: now.Add(-time.Hour).UnixMilli()}, now))
+	require.Equal(t, SubscriptionDead, subscriptionHealth(&subscriptionRecord{
+		Expiration: now.Add(3 * 24 * time.Hour).UnixMilli(),
+		ReplacedBy: "new_id",
+	}, now))
+}
+
+func TestListSubscriptionStatuses(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	now := time.Now()
+	notifiedAt := now.Add(-time.Minute).UnixMilli()
+	record1, err := json.Marshal(&subscriptionRecord{
+		ID:               "id_1",
+		ResourceID:       "resource_1",
+		CalendarID:       "primary",
+		CreatorID:        "remote_user_1",
+		MattermostUserID: "user_1",
+		Expiration:       now.Add(3 * 24 * time.Hour).UnixMilli(),
+	})
+	require.NoError(t, err)
+	record2, err := json.Marshal(&subscriptionRecord{
+		ID:               "id_2",
+		ResourceID:       "resource_2",
+		CalendarID:       "primary",
+		MattermostUserID: "user_0",
+		Expiration:       now.Add(-time.Hour).UnixMilli(),
+	})
+	require.NoError(t, err)
+	lastNotification, err := json.Marshal(notifiedAt)
+	require.NoError(t, err)
+
+	api := &plugintest.API{}
+	api.On("KVGet", subscriptionRegistryKey).Return([]byte(`["id_1","id_2","deleted_id"]`), nil)
+	api.On("KVGet", subscriptionKey(subscriptionKeyPrefix, "deleted_id")).Return(nil, nil)
+	api.On("KVGet", subscriptionKey(subscriptionKeyPrefix, "id_1")).Return(record1, nil)
+	api.On("KVGet", subscriptionKey(subscriptionKeyPrefix, "id_2")).Return(record2, nil)
+	api.On("KVGet", subscriptionKey(lastNotificationKeyPrefix, "id_1")).Return(lastNotification, nil)
+	api.On("KVGet", subscriptionKey(lastNotificationKeyPrefix, "id_2")).Return(nil, nil)
+	SetPluginAPI(api)
+
+	statuses, err := ListSubscriptionStatuses()
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+
+	require.Equal(t, "id_2", statuses[0].ID)
+	require.Equal(t, SubscriptionDead, statuses[0].Health)
+	require.True(t, statuses[0].LastNotificationAt.IsZero())
+
+	require.Equal(t, "id_1", statuses[1].ID)
+	require.Equal(t, "resource_1", statuses[1].ResourceID)
+	require.Equal(t, "primary", statuses[1].CalendarID)
+	require.Equal(t, "user_1", statuses[1].MattermostUserID)
+	require.Equal(t, "remote_user_1", statuses[1].RemoteUserID)
+	require.Equal(t, SubscriptionHealthy, statuses[1].Health)
+	require.Equal(t, notifiedAt, statuses[1].LastNotificationAt.UnixMilli())
+
+	// Users only list their own subscriptions
+	subs, err := (&client{mattermostUserID: "user_1"}).ListSubscriptions()
+	require.NoError(t, err)
+	require.Len(t, subs, 1)
+	require.Equal(t, "id_1", subs[0].ID)
+	require.Equal(t, "primary", subs[0].Resource)
+	require.Equal(t, "remote_user_1", subs[0].CreatorID)
+
+	subs, err = (&client{mattermostUserID: "user_2"}).ListSubscriptions()
+	require.NoError(t, err)
+	require.Empty(t, subs)
+}
+
+func TestDeleteSubscription(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	for name, tc := range map[string]struct {
+		status      int
+		expectError bool
+	}{
+		"stopped":         {status: http.StatusNoContent},
+		"unknown channel": {status: http.StatusNotFound},
+		"expired channel": {status: http.StatusGone},
+		"google error":    {status: http.StatusInternalServerError, expectError: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/calendar/v3/channels/stop", r.URL.Path)
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+			serverURL, err := url.Parse(server.URL)
+			require.NoError(t, err)
+
+			api := &plugintest.API{}
+			api.On("KVDelete", mock.Anything).Return(nil)
+			api.On("KVGet", subscriptionRegistryKey).Return([]byte(`["id_1","id_2"]`), nil)
+			api.On("KVCompareAndSet", subscriptionRegistryKey, []byte(`["id_1","id_2"]`), []byte(`["id_2"]`)).Return(true, nil)
+			SetPluginAPI(api)
+
+			c := &client{
+				httpClient: &http.Client{Transport: &redirectTransport{url: serverURL}},
+				Logger:     &testLogger{},
+			}
+			err = c.DeleteSubscription(&remote.Subscription{ID: "id_1", ResourceID: "resource_1"})
+			if tc.expectError {
+				require.Error(t, err)
+				api.AssertNotCalled(t, "KVDelete", mock.Anything)
+				api.AssertNotCalled(t, "KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+
+			require.NoError(t, err)
+			api.AssertCalled(t, "KVDelete", subscriptionKey(subscriptionKeyPrefix, "id_1"))
+			api.AssertCalled(t, "KVDelete", subscriptionKey(pendingNotificationsKeyPrefix, "id_1"))
+			api.AssertExpectations(t)
+		})
+	}
+}
+
+// testLogger drops the logs of the client
+type testLogger struct{}
+
+func (l *testLogger) With(bot.LogContext) bot.Logger { return l }
+func (l *testLogger) Timed() bot.Logger              { return l }
+func (l *testLogger) Debugf(string, ...any)          {}
+func (l *testLogger) Errorf(string, ...any)          {}
+func (l *testLogger) Infof(string, ...any)           {}
+func (l *testLogger) Warnf(string, ...any)           {}
+
+func TestUpdateSubscriptionRegistry(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	t.Run("first subscription", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", subscriptionRegistryKey).Return(nil, nil)
+		api.On("KVCompareAndSet", subscriptionRegistryKey, []byte(nil), []byte(`["id_1"]`)).Return(true, nil)
+		SetPluginAPI(api)
+
+		require.NoError(t, updateSubscriptionRegistry("id_1", true))
+		api.AssertExpectations(t)
+	})
+
+	t.Run("subscription added in the meantime", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", subscriptionRegistryKey).Return([]byte(`["id_1"]`), nil).Once()
+		api.On("KVCompareAndSet", subscriptionRegistryKey, []byte(`["id_1"]`), []byte(`["id_1","id_3"]`)).Return(false, nil).Once()
+		api.On("KVGet", subscriptionRegistryKey).Return([]byte(`["id_1","id_2"]`), nil).Once()
+		api.On("KVCompareAndSet", subscriptionRegistryKey, []byte(`["id_1","id_2"]`), []byte(`["id_1","id_2","id_3"]`)).Return(true, nil).Once()
+		SetPluginAPI(api)
+
+		require.NoError(t, updateSubscriptionRegistry("id_3", true))
+		api.AssertExpectations(t)
+	})
+
+	t.Run("removed subscription", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", subscriptionRegistryKey).Return([]byte(`["id_1","id_2"]`), nil)
+		api.On("KVCompareAndSet", subscriptionRegistryKey, []byte(`["id_1","id_2"]`), []byte(`["id_2"]`)).Return(true, nil)
+		SetPluginAPI(api)
+
+		require.NoError(t, updateSubscriptionRegistry("id_1", false))
+		api.AssertExpectations(t)
+	})
+
+	t.Run("unknown subscription", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", subscriptionRegistryKey).Return([]byte(`["id_2"]`), nil)
+		SetPluginAPI(api)
+
+		require.NoError(t, updateSubscriptionRegistry("id_1", false))
+		api.AssertNotCalled(t, "KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+// How out of office and focus time events decline the invitations received during them
+const (
+	AutoDeclineNone               = "declineNone"
+	AutoDeclineAllConflicting     = "declineAllConflictingInvitations"
+	AutoDeclineOnlyNewConflicting = "declineOnlyNewConflictingInvitations"
+)
+
+// Chat status of the user during a focus time event
+const (
+	ChatStatusAvailable    = "available"
+	ChatStatusDoNotDisturb = "doNotDisturb"
+)
+
+const (
+	defaultFocusTimeSummary   = "Focus time"
+	defaultOutOfOfficeSummary = "Out of office"
+)
+
+// FocusTime are the details of a focus time event
+type FocusTime struct {
+	// AutoDeclineMode is how Google declines the invitations received during the event
+	AutoDeclineMode string
+	// DeclineMessage is the message sent with the declined invitations
+	DeclineMessage string
+	// ChatStatus is the status of the user in Google Chat during the event
+	ChatStatus string
+}
+
+// CreateFocusTimeEvent blocks time for focus on the primary calendar of the user
+func (c *client) CreateFocusTimeEvent(subject string, start, end *remote.DateTime, focusTime *FocusTime) (*remote.Event, error) {
+	if focusTime == nil {
+		focusTime = &FocusTime{}
+	}
+
+	err := validateAutoDeclineMode(focusTime.AutoDeclineMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal CreateFocusTimeEvent")
+	}
+	switch focusTime.ChatStatus {
+	case "", ChatStatusAvailable, ChatStatusDoNotDisturb:
+	default:
+		return nil, errors.Errorf("gcal CreateFocusTimeEvent, invalid chat status %q", focusTime.ChatStatus)
+	}
+
+	if subject == "" {
+		subject = defaultFocusTimeSummary
+	}
+
+	evt, err := newTimeBlockEvent(GoogleEventTypeFocusTime, subject, start, end)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal CreateFocusTimeEvent")
+	}
+	evt.FocusTimeProperties = &calendar.EventFocusTimeProperties{
+		AutoDeclineMode: focusTime.AutoDeclineMode,
+		DeclineMessage:  focusTime.DeclineMessage,
+		ChatStatus:      focusTime.ChatStatus,
+	}
+
+	result, err := c.insertTimeBlockEvent(evt)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal CreateFocusTimeEvent")
+	}
+
+	return result, nil
+}
+
+// CreateOutOfOfficeEvent blocks time out of office on the primary calendar of the user
+func (c *client) CreateOutOfOfficeEvent(subject string, start, end *remote.DateTime, outOfOffice *OutOfOffice) (*remote.Event, error) {
+	if outOfOffice == nil {
+		outOfOffice = &OutOfOffice{}
+	}
+
+	err := validateAutoDeclineMode(outOfOffice.AutoDeclineMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal CreateOutOfOfficeEvent")
+	}
+
+	if subject == "" {
+		subject = defaultOutOfOfficeSummary
+	}
+
+	evt, err := newTimeBlockEvent(GoogleEventTypeOutOfOffice, subject, start, end)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal CreateOutOfOfficeEvent")
+	}
+	evt.OutOfOfficeProperties = &calendar.EventOutOfOfficeProperties{
+		AutoDeclineMode: outOfOffice.AutoDeclineMode,
+		DeclineMessage:  outOfOffice.DeclineMessage,
+	}
+
+	result, err := c.insertTimeBlockEvent(evt)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal CreateOutOfOfficeEvent")
+	}
+
+	return result, nil
+}
+
+func validateAutoDeclineMode(mode string) error {
+	switch mode {
+	case "", AutoDeclineNone, AutoDeclineAllConflicting, AutoDeclineOnlyNewConflicting:
+		return nil
+	}
+	return errors.Errorf("invalid auto decline mode %q", mode)
+}
+
+// newTimeBlockEvent returns an event of the given type blocking the time of the user.
+// Google doesn't accept all-day out of office and focus time events.
+func newTimeBlockEvent(eventType, subject string, start, end *remote.DateTime) (*calendar.Event, error) {
+	if start == nil || end == nil {
+		return nil, errors.New("start and end are required")
+	}
+
+	evt := &calendar.Event{
+		EventType:    eventType,
+		Summary:      subject,
+		Start:        convertRemoteDateTimeToGcalEventDateTime(start),
+		End:          convertRemoteDateTimeToGcalEventDateTime(end),
+		Transparency: GoogleEventBusy,
+	}
+	if evt.Start.DateTime == "" || evt.End.DateTime == "" {
+		return nil, errors.New("start and end must be set with a time")
+	}
+
+	return evt, nil
+}
+
+// insertTimeBlockEvent creates the event on the primary calendar, the only one accepting special event types
+func (c *client) insertTimeBlockEvent(evt *calendar.Event) (*remote.Event, error) {
+	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating service")
+	}
+
+	result, err := service.Events.Insert(defaultCalendarName, evt).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating event")
+	}
+	c.expireEventCache(defaultCalendarName)
+
+	return convertGCalEventToRemoteEvent(result), nil
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+)
+
+func TestNewTimeBlockEvent(t *testing.T) {
+	start := remote.NewDateTime(time.Date(2023, 8, 1, 9, 0, 0, 0, time.UTC), "UTC")
+	end := remote.NewDateTime(time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC), "UTC")
+
+	evt, err := newTimeBlockEvent(GoogleEventTypeFocusTime, "Deep work", start, end)
+	require.NoError(t, err)
+	require.Equal(t, GoogleEventTypeFocusTime, evt.EventType)
+	require.Equal(t, "Deep work", evt.Summary)
+	require.Equal(t, GoogleEventBusy, evt.Transparency)
+	require.NotEmpty(t, evt.Start.DateTime)
+	require.NotEmpty(t, evt.End.DateTime)
+
+	_, err = newTimeBlockEvent(GoogleEventTypeOutOfOffice, "Vacation", start, nil)
+	require.Error(t, err)
+}
+
+func TestValidateAutoDeclineMode(t *testing.T) {
+	for _, mode := range []string{"", AutoDeclineNone, AutoDeclineAllConflicting, AutoDeclineOnlyNewConflicting} {
+		require.NoError(t, validateAutoDeclineMode(mode))
+	}
+	require.Error(t, validateAutoDeclineMode("declineEverything"))
+}
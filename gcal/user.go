@@ -5,6 +5,7 @@ package gcal
 
 import (
 	"context"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -42,6 +43,8 @@ func (c *client) GetMe() (*remote.User, error) {
 		email = user.EmailAddresses[0].Value
 	}
 
+	c.rememberGoogleEmail(email)
+
 	remoteUser := &remote.User{
 		ID:                user.ResourceName,
 		DisplayName:       name,
@@ -55,3 +58,26 @@ func (c *client) GetMe() (*remote.User, error) {
 func (c *client) GetSuperuserToken() (string, error) {
 	return "", remote.ErrNotImplemented
 }
+
+// rememberGoogleEmail stores the email of the Google account connected by the user, so the calendars
+// linked to their channels can be shared with them on the next periodic sync
+func (c *client) rememberGoogleEmail(email string) {
+	if pluginAPI == nil || c.mattermostUserID == "" {
+		return
+	}
+
+	if !strings.Contains(email, "@") {
+		// The ID of the primary calendar is the email of the account
+		cal, err := c.GetDefaultCalendar()
+		if err != nil {
+			c.Logger.Warnf("gcal: error getting the email of the connected account. err=%v", err)
+			return
+		}
+		email = cal.ID
+	}
+
+	err := storeGoogleEmail(c.mattermostUserID, email)
+	if err != nil {
+		c.Logger.Warnf("gcal: error storing the email of the connected account. err=%v", err)
+	}
+}
@@ -6,6 +6,10 @@ package gcal
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
 )
 
 // newRandomString generates a random string used for subscription ID and token
@@ -14,3 +18,23 @@ func newRandomString() string {
 	_, _ = rand.Read(b)
 	return base64.URLEncoding.EncodeToString(b)
 }
+
+// isNotFoundError returns true if the error is a Google API response for a resource that doesn't exist or was deleted
+func isNotFoundError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone
+}
+
+// isGoneError returns true if the error is a Google API response for an expired sync token
+func isGoneError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.Code == http.StatusGone
+}
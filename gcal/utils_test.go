@@ -0,0 +1,26 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsNotFoundError(t *testing.T) {
+	require.True(t, isNotFoundError(&googleapi.Error{Code: http.StatusNotFound}))
+	require.True(t, isNotFoundError(errors.Wrap(&googleapi.Error{Code: http.StatusGone}, "wrapped")))
+	require.False(t, isNotFoundError(&googleapi.Error{Code: http.StatusForbidden}))
+	require.False(t, isNotFoundError(errors.New("not found")))
+}
+
+func TestIsGoneError(t *testing.T) {
+	require.True(t, isGoneError(errors.Wrap(&googleapi.Error{Code: http.StatusGone}, "wrapped")))
+	require.False(t, isGoneError(&googleapi.Error{Code: http.StatusNotFound}))
+	require.False(t, isGoneError(errors.New("gone")))
+}
@@ -5,6 +5,8 @@ package gcal
 
 import (
 	"context"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
@@ -13,12 +15,17 @@ import (
 
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
 )
 
 const (
 	RemoteEventBusy = "busy"
 	RemoteEventFree = "free"
 
+	RemoteEventOutOfOffice      = "oof"
+	RemoteEventFocusTime        = "focusTime"
+	RemoteEventWorkingElsewhere = "workingElsewhere"
+
 	GoogleEventBusy = "opaque"
 	GoogleEventFree = "transparent"
 
@@ -35,43 +42,127 @@ var responseStatusConversion = map[string]string{
 	GoogleResponseStatusNone:  remote.EventResponseStatusNotAnswered,
 }
 
+// GetDefaultCalendarView returns the events of all the calendars selected by the user between two dates
 func (c *client) GetDefaultCalendarView(_ string, start, end time.Time) ([]*remote.Event, error) {
-	service, err := calendar.NewService(context.Background(), option.WithHTTPClient(c.httpClient))
+	events, err := c.getSelectedCalendarsEvents(start, end)
+	if err != nil {
+		return nil, errors.Wrap(err, "gcal GetDefaultCalendarView")
+	}
+
+	result := []*remote.Event{}
+	for _, event := range events {
+		if event.ICalUID != "" {
+			result = append(result, event)
+		}
+	}
+
+	return result, nil
+}
+
+// getSelectedCalendarsEvents returns the events of all the calendars selected by the user between two dates,
+// merged and sorted by start time
+func (c *client) getSelectedCalendarsEvents(start, end time.Time) ([]*remote.Event, error) {
+	ctx := context.Background()
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating service")
+	}
+
+	calendarIDs, err := c.getSelectedCalendarIDs()
 	if err != nil {
-		return nil, errors.Wrap(err, "gcal GetDefaultCalendarView, error creating service")
+		return nil, errors.Wrap(err, "error getting selected calendars")
 	}
 
-	req := service.Events.
-		List(defaultCalendarName).
-		EventTypes("default").
+	eventsByCalendar := make([][]*calendar.Event, 0, len(calendarIDs))
+	for _, calendarID := range calendarIDs {
+		events, err := c.listCachedEvents(ctx, service, calendarID, start, end)
+		if err != nil {
+			if calendarID == defaultCalendarName {
+				return nil, errors.Wrap(err, "error getting list of events")
+			}
+
+			// Access to a secondary calendar can be revoked at any time, that shouldn't hide the rest of the events
+			c.Logger.With(bot.LogContext{
+				"calendarID": calendarID,
+			}).Warnf("gcal: error getting list of events from secondary calendar. err=%v", err)
+			continue
+		}
+
+		eventsByCalendar = append(eventsByCalendar, events)
+	}
+
+	events := mergeCalendarEvents(eventsByCalendar...)
+	c.applyReminderPreference(events...)
+
+	return events, nil
+}
+
+// listEvents returns the single events of a calendar between two dates
+func listEvents(ctx context.Context, service *calendar.Service, calendarID string, start, end time.Time) ([]*calendar.Event, error) {
+	events := []*calendar.Event{}
+	err := service.Events.
+		List(calendarID).
+		EventTypes(supportedEventTypes...).
 		TimeMin(start.Format(time.RFC3339)).
 		TimeMax(end.Format(time.RFC3339)).
 		SingleEvents(true).
 		ShowDeleted(false).
 		ShowHiddenInvitations(false).
 		OrderBy("startTime").
-		Context(context.TODO())
-
-	result, err := req.Do()
+		Pages(ctx, func(page *calendar.Events) error {
+			applyDefaultReminders(page.Items, page.DefaultReminders)
+			events = append(events, page.Items...)
+			return nil
+		})
 	if err != nil {
-		return nil, errors.Wrap(err, "gcal GetDefaultCalendarView, error performing request")
+		return nil, err
 	}
 
-	if len(result.Items) == 0 {
-		return []*remote.Event{}, nil
-	}
+	return events, nil
+}
 
+// mergeCalendarEvents converts and merges the events of several calendars, sorted by start time.
+// The same meeting shows up in every calendar it was invited to, so events are deduplicated by
+// ICalUID. The start time is part of the key since every instance of a recurring event shares the
+// same ICalUID. It is compared as an instant, as each calendar gives it with its own offset. When
+// duplicated, the event from the first calendar is kept. Working location events are left out, as
+// they only set the custom status of the user and don't take their time.
+func mergeCalendarEvents(eventsByCalendar ...[]*calendar.Event) []*remote.Event {
+	seen := map[string]bool{}
 	events := []*remote.Event{}
-	for _, event := range result.Items {
-		if event.ICalUID != "" {
+	for _, calendarEvents := range eventsByCalendar {
+		for _, event := range calendarEvents {
+			if event.EventType == GoogleEventTypeWorkingLocation {
+				continue
+			}
+
+			key := event.Id
+			if start, err := parseEventDateTime(event.Start); event.ICalUID != "" && err == nil {
+				key = event.ICalUID + "_" + strconv.FormatInt(start.UnixMilli(), 10)
+			}
+
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
 			events = append(events, convertGCalEventToRemoteEvent(event))
 		}
 	}
 
-	return events, nil
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start.Time().Before(events[j].Start.Time())
+	})
+
+	return events
 }
 
 func convertGCalEventDateTimeToRemoteDateTime(dt *calendar.EventDateTime) *remote.DateTime {
+	// Cancelled events may only come with their ID and status
+	if dt == nil {
+		return nil
+	}
+
 	// Handle all-day events
 	if len(dt.Date) > 0 {
 		t, _ := time.Parse("2006-01-02", dt.Date)
@@ -83,10 +174,7 @@ func convertGCalEventDateTimeToRemoteDateTime(dt *calendar.EventDateTime) *remot
 }
 
 func convertGCalEventToRemoteEvent(event *calendar.Event) *remote.Event {
-	showAs := RemoteEventBusy
-	if event.Transparency == GoogleEventFree {
-		showAs = RemoteEventFree
-	}
+	showAs := convertGCalEventShowAs(event)
 
 	start := convertGCalEventDateTimeToRemoteDateTime(event.Start)
 	end := convertGCalEventDateTimeToRemoteDateTime(event.End)
@@ -94,30 +182,51 @@ func convertGCalEventToRemoteEvent(event *calendar.Event) *remote.Event {
 	var conference *remote.Conference
 	var location *remote.Location
 
-	if event.ConferenceData != nil && len(event.ConferenceData.EntryPoints) > 0 {
+	eventConference := convertGCalEventToConference(event)
+	if eventConference != nil {
 		conference = &remote.Conference{
-			URL: event.ConferenceData.EntryPoints[0].Uri,
-		}
-		if event.ConferenceData.ConferenceSolution != nil {
-			conference.Application = event.ConferenceData.ConferenceSolution.Name
+			URL:         eventConference.VideoURL(),
+			Application: eventConference.Application,
 		}
+	} else if mattermostCall := detectMattermostCall(event.Description); mattermostCall != nil {
+		conference = mattermostCall
 	} else if utils.IsURL(event.Location) {
 		conference = &remote.Conference{
 			URL: event.Location,
 		}
+	} else {
+		conference = detectConference(event.Description)
 	}
 
 	if !utils.IsURL(event.Location) {
 		location = &remote.Location{
 			DisplayName: event.Location,
 		}
+		if location.DisplayName == "" && event.EventType == GoogleEventTypeWorkingLocation {
+			location.DisplayName = workingLocationDisplayName(event.WorkingLocationProperties)
+		}
+	}
+
+	// Reminders and summaries show the location of the event, so the dial-in details are added to it for the
+	// attendees joining by phone
+	if eventConference != nil {
+		if dialIn := eventConference.dialInDetails(); dialIn != "" {
+			if location == nil || location.DisplayName == "" {
+				location = &remote.Location{DisplayName: dialIn}
+			} else {
+				location.DisplayName += ", " + dialIn
+			}
+		}
 	}
 
-	organizer := &remote.Attendee{
-		EmailAddress: &remote.EmailAddress{
-			Name:    event.Organizer.Email,
-			Address: event.Organizer.Email,
-		},
+	var organizer *remote.Attendee
+	if event.Organizer != nil {
+		organizer = &remote.Attendee{
+			EmailAddress: &remote.EmailAddress{
+				Name:    event.Organizer.Email,
+				Address: event.Organizer.Email,
+			},
+		}
 	}
 
 	responseStatus := &remote.EventResponseStatus{
@@ -150,29 +259,29 @@ func convertGCalEventToRemoteEvent(event *calendar.Event) *remote.Event {
 		}
 	}
 
-	isAllDay := len(event.Start.Date) > 0 // if Date field is present, it is all-day. as opposed to DateTime field
+	isAllDay := event.Start != nil && len(event.Start.Date) > 0 // if Date field is present, it is all-day. as opposed to DateTime field
 
 	return &remote.Event{
-		ID:                event.Id,
-		ICalUID:           event.ICalUID,
-		Subject:           event.Summary,
-		Body:              &remote.ItemBody{Content: event.Description},
-		BodyPreview:       event.Description, // GCAL TODO no body preview available?
-		IsAllDay:          isAllDay,
-		ShowAs:            showAs,
-		Weblink:           event.HtmlLink,
-		Start:             start,
-		End:               end,
-		Location:          location,
-		Conference:        conference,
-		Organizer:         organizer,
-		Attendees:         attendees,
-		ResponseStatus:    responseStatus,
-		IsCancelled:       event.Status == "cancelled",
-		IsOrganizer:       isOrganizer,
-		ResponseRequested: responseRequested,
+		ID:                         event.Id,
+		ICalUID:                    event.ICalUID,
+		Subject:                    event.Summary,
+		Body:                       &remote.ItemBody{Content: event.Description},
+		BodyPreview:                event.Description, // GCAL TODO no body preview available?
+		IsAllDay:                   isAllDay,
+		ShowAs:                     showAs,
+		Weblink:                    event.HtmlLink,
+		Start:                      start,
+		End:                        end,
+		Location:                   location,
+		Conference:                 conference,
+		Organizer:                  organizer,
+		Attendees:                  attendees,
+		ResponseStatus:             responseStatus,
+		IsCancelled:                event.Status == "cancelled",
+		IsOrganizer:                isOrganizer,
+		ResponseRequested:          responseRequested,
+		ReminderMinutesBeforeStart: reminderMinutesBeforeStart(event.Reminders),
 		// 	Importance                 string
-		// 	ReminderMinutesBeforeStart int
 	}
 }
 
@@ -29,6 +29,38 @@ func createMinimalCalendarEvent() calendar.Event {
 	}
 }
 
+func createMultipleEntryPointsConferenceData() *calendar.ConferenceData {
+	return &calendar.ConferenceData{
+		ConferenceSolution: &calendar.ConferenceSolution{
+			Name: "Google Meet",
+		},
+		EntryPoints: []*calendar.EntryPoint{
+			{
+				EntryPointType: EntryPointTypePhone,
+				Label:          "+1 555-0100",
+				Uri:            "tel:+1-555-0100",
+				Pin:            "123456789",
+			},
+			{
+				EntryPointType: EntryPointTypeVideo,
+				Label:          "meet.google.com/abc-defg-hij",
+				Uri:            "https://meet.google.com/abc-defg-hij",
+			},
+			{
+				EntryPointType: EntryPointTypeSIP,
+				Label:          "abc-defg-hij@meet.example.com",
+				Uri:            "sip:abc-defg-hij@meet.example.com",
+				Passcode:       "4321",
+			},
+			{
+				EntryPointType: EntryPointTypeMore,
+				Uri:            "https://tel.meet/abc-defg-hij",
+				AccessCode:     "9876",
+			},
+		},
+	}
+}
+
 func TestConvertGCalEventToRemoteEvent(t *testing.T) {
 	for _, tc := range []struct {
 		Name  string
@@ -131,6 +163,146 @@ func TestConvertGCalEventToRemoteEvent(t *testing.T) {
 				require.Empty(t, event.Location)
 			},
 		},
+		{
+			Name: "video entry point is used as conference url",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.ConferenceData = createMultipleEntryPointsConferenceData()
+				return evt
+			},
+			Check: func(t *testing.T, event *remote.Event) {
+				require.Equal(t, "https://meet.google.com/abc-defg-hij", event.Conference.URL)
+				require.Equal(t, "Google Meet", event.Conference.Application)
+				require.Equal(t, "Dial-in: +1 555-0100 (PIN: 123456789)", event.Location.DisplayName)
+			},
+		},
+		{
+			Name: "dial-in details are added to the location",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.Location = "Room 1"
+				evt.ConferenceData = &calendar.ConferenceData{
+					EntryPoints: []*calendar.EntryPoint{{
+						EntryPointType: EntryPointTypePhone,
+						Uri:            "tel:+49-30-555-0100",
+						Passcode:       "4321",
+					}},
+				}
+				return evt
+			},
+			Check: func(t *testing.T, event *remote.Event) {
+				require.Equal(t, "Room 1, Dial-in: +49-30-555-0100 (passcode: 4321)", event.Location.DisplayName)
+			},
+		},
+		{
+			Name: "hangout link used as conference if no conference data is present",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.HangoutLink = "https://meet.google.com/abc-defg-hij"
+				return evt
+			},
+			Check: func(t *testing.T, event *remote.Event) {
+				require.Equal(t, "https://meet.google.com/abc-defg-hij", event.Conference.URL)
+				require.Equal(t, hangoutLinkApplication, event.Conference.Application)
+			},
+		},
+		{
+			Name: "meeting link in the description used as conference",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.Location = "Room 1"
+				evt.Description = `Join the meeting: <a href="https://us02web.zoom.us/j/123456789?pwd=abc">https://us02web.zoom.us/j/123456789?pwd=abc</a>`
+				return evt
+			},
+			Check: func(t *testing.T, event *remote.Event) {
+				require.Equal(t, "https://us02web.zoom.us/j/123456789?pwd=abc", event.Conference.URL)
+				require.Equal(t, "Zoom", event.Conference.Application)
+				require.Equal(t, "Room 1", event.Location.DisplayName)
+			},
+		},
+		{
+			Name: "out of office event",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.EventType = GoogleEventTypeOutOfOffice
+				evt.Summary = "Vacation"
+				return evt
+			},
+			Check: func(t *testing.T, event *remote.Event) {
+				require.Equal(t, RemoteEventOutOfOffice, event.ShowAs)
+				require.Equal(t, "Vacation", event.Subject)
+			},
+		},
+		{
+			Name: "focus time event",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.EventType = GoogleEventTypeFocusTime
+				return evt
+			},
+			Check: func(t *testing.T, event *remote.Event) {
+				require.Equal(t, RemoteEventFocusTime, event.ShowAs)
+			},
+		},
+		{
+			Name: "working location event",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.EventType = GoogleEventTypeWorkingLocation
+				evt.Transparency = GoogleEventFree
+				evt.WorkingLocationProperties = &calendar.EventWorkingLocationProperties{
+					Type: "officeLocation",
+					OfficeLocation: &calendar.EventWorkingLocationPropertiesOfficeLocation{
+						Label: "Palo Alto HQ",
+					},
+				}
+				return evt
+			},
+			Check: func(t *testing.T, event *remote.Event) {
+				require.Equal(t, RemoteEventWorkingElsewhere, event.ShowAs)
+				require.Equal(t, "Palo Alto HQ", event.Location.DisplayName)
+			},
+		},
+		{
+			Name: "free event",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.Transparency = GoogleEventFree
+				return evt
+			},
+			Check: func(t *testing.T, event *remote.Event) {
+				require.Equal(t, RemoteEventFree, event.ShowAs)
+			},
+		},
+		{
+			Name: "mattermost calls conference is recognized",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.Location = "https://mattermost.example.com/team/channels/town-square"
+				evt.Description = addMattermostCallToDescription("Weekly sync", "https://mattermost.example.com/team/channels/town-square")
+				return evt
+			},
+			Check: func(t *testing.T, event *remote.Event) {
+				require.Equal(t, "https://mattermost.example.com/team/channels/town-square", event.Conference.URL)
+				require.Equal(t, MattermostCallsApplication, event.Conference.Application)
+				require.Empty(t, event.Location)
+			},
+		},
+		{
+			Name: "cancelled events without details are converted",
+			In: func() calendar.Event {
+				return calendar.Event{
+					Id:     "event_id",
+					Status: "cancelled",
+				}
+			},
+			Check: func(t *testing.T, event *remote.Event) {
+				require.Equal(t, "event_id", event.ID)
+				require.True(t, event.IsCancelled)
+				require.Nil(t, event.Start)
+				require.Nil(t, event.Organizer)
+			},
+		},
 	} {
 		t.Run(tc.Name, func(t *testing.T) {
 			event := tc.In()
@@ -139,3 +311,125 @@ func TestConvertGCalEventToRemoteEvent(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeCalendarEvents(t *testing.T) {
+	newEvent := func(id, iCalUID, start string) *calendar.Event {
+		evt := createMinimalCalendarEvent()
+		evt.Id = id
+		evt.ICalUID = iCalUID
+		evt.Start.DateTime = start
+		return &evt
+	}
+
+	primary := []*calendar.Event{
+		newEvent("standup_1", "standup@google.com", "2023-08-01T09:00:00Z"),
+		newEvent("standup_2", "standup@google.com", "2023-08-02T09:00:00Z"),
+		newEvent("review", "review@google.com", "2023-08-01T15:00:00Z"),
+		newEvent("working_location", "working_location@google.com", "2023-08-01T00:00:00Z"),
+	}
+	primary[3].EventType = GoogleEventTypeWorkingLocation
+	team := []*calendar.Event{
+		newEvent("team_standup_1", "standup@google.com", "2023-08-01T09:00:00Z"),
+		newEvent("oncall", "oncall@google.com", "2023-08-01T08:00:00Z"),
+		// The same occurrences as given by calendars in other time zones
+		newEvent("team_standup_2", "standup@google.com", "2023-08-02T11:00:00+02:00"),
+		newEvent("team_review", "review@google.com", "2023-08-01T08:00:00-07:00"),
+	}
+
+	events := mergeCalendarEvents(primary, team)
+
+	ids := []string{}
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	require.Equal(t, []string{"oncall", "standup_1", "review", "standup_2"}, ids)
+}
+
+func TestConvertGCalEventToConference(t *testing.T) {
+	tcs := []struct {
+		Name  string
+		In    func() calendar.Event
+		Check func(t *testing.T, conference *Conference)
+	}{
+		{
+			Name: "event without conference",
+			In:   createMinimalCalendarEvent,
+			Check: func(t *testing.T, conference *Conference) {
+				require.Nil(t, conference)
+			},
+		},
+		{
+			Name: "all entry points are kept",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.ConferenceData = createMultipleEntryPointsConferenceData()
+				return evt
+			},
+			Check: func(t *testing.T, conference *Conference) {
+				require.Equal(t, "Google Meet", conference.Application)
+				require.Equal(t, []ConferenceEntryPoint{
+					{Type: EntryPointTypePhone, Label: "+1 555-0100", URI: "tel:+1-555-0100", Pin: "123456789"},
+					{Type: EntryPointTypeVideo, Label: "meet.google.com/abc-defg-hij", URI: "https://meet.google.com/abc-defg-hij"},
+					{Type: EntryPointTypeSIP, Label: "abc-defg-hij@meet.example.com", URI: "sip:abc-defg-hij@meet.example.com", Passcode: "4321"},
+					{Type: EntryPointTypeMore, URI: "https://tel.meet/abc-defg-hij", Passcode: "9876"},
+				}, conference.EntryPoints)
+				require.Equal(t, "https://meet.google.com/abc-defg-hij", conference.VideoURL())
+				require.Equal(t, "tel:+1-555-0100", conference.DialIn().URI)
+			},
+		},
+		{
+			Name: "conference without video or phone entry points",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.ConferenceData = &calendar.ConferenceData{
+					EntryPoints: []*calendar.EntryPoint{{
+						EntryPointType: EntryPointTypeSIP,
+						Uri:            "sip:room@example.com",
+					}},
+				}
+				return evt
+			},
+			Check: func(t *testing.T, conference *Conference) {
+				require.Empty(t, conference.Application)
+				require.Equal(t, "sip:room@example.com", conference.VideoURL())
+				require.Nil(t, conference.DialIn())
+			},
+		},
+		{
+			Name: "legacy hangout link",
+			In: func() calendar.Event {
+				evt := createMinimalCalendarEvent()
+				evt.HangoutLink = "https://meet.google.com/abc-defg-hij"
+				return evt
+			},
+			Check: func(t *testing.T, conference *Conference) {
+				require.Equal(t, hangoutLinkApplication, conference.Application)
+				require.Equal(t, "https://meet.google.com/abc-defg-hij", conference.VideoURL())
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			evt := tc.In()
+			tc.Check(t, convertGCalEventToConference(&evt))
+		})
+	}
+}
+
+func TestWorkingLocationDisplayName(t *testing.T) {
+	require.Empty(t, workingLocationDisplayName(nil))
+	require.Equal(t, "Home", workingLocationDisplayName(&calendar.EventWorkingLocationProperties{
+		Type:       "homeOffice",
+		HomeOffice: map[string]any{},
+	}))
+	require.Equal(t, "Office", workingLocationDisplayName(&calendar.EventWorkingLocationProperties{
+		Type: "officeLocation",
+	}))
+	require.Equal(t, "Cafe", workingLocationDisplayName(&calendar.EventWorkingLocationProperties{
+		Type: "customLocation",
+		CustomLocation: &calendar.EventWorkingLocationPropertiesCustomLocation{
+			Label: "Cafe",
+		},
+	}))
+}
@@ -4,9 +4,16 @@
 package gcal
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/utils/bot"
 )
 
 type webhook struct {
@@ -26,6 +33,152 @@ const (
 	resourceStateNotExists = "not_exists"
 )
 
+const (
+	// webhookRateLimitWindow, webhookChannelRateLimit and webhookRateLimit bound the webhooks handled per channel
+	// and overall, as each of them leads to Google API calls. The changes of the dropped ones are synced with
+	// the next webhook of the channel.
+	webhookRateLimitWindow  = time.Minute
+	webhookChannelRateLimit = 30
+	webhookRateLimit        = 3000
+
+	// renewRecommendedBefore is how long before the expiration of a subscription its renewal is recommended,
+	// at most half of the subscription TTL
+	renewRecommendedBefore = 24 * time.Hour
+
+	// channelExpirationFormat is the format of the X-Goog-Channel-Expiration header
+	channelExpirationFormat = time.RFC1123
+)
+
+// webhookRateLimiter counts the webhooks handled in the current window
+type webhookRateLimiter struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	total       int
+	byChannel   map[string]int
+}
+
+var webhookLimiter = &webhookRateLimiter{}
+
+// allow returns true if a webhook for the channel can be handled now
+func (l *webhookRateLimiter) allow(channelID string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.windowStart) >= webhookRateLimitWindow {
+		l.windowStart = now
+		l.total = 0
+		l.byChannel = map[string]int{}
+	}
+
+	if l.total >= webhookRateLimit || l.byChannel[channelID] >= webhookChannelRateLimit {
+		return false
+	}
+
+	l.total++
+	l.byChannel[channelID]++
+	return true
+}
+
+// verifyWebhook checks the webhook comes from Google for a known subscription, and wasn't handled already.
+// It returns the status code to answer with when the webhook must be dropped, or 0 if it must be handled.
+func verifyWebhook(req *http.Request, now time.Time) (int, *subscriptionRecord, error) {
+	channelID := req.Header.Get("X-Goog-Channel-Id")
+	token := req.Header.Get("X-Goog-Channel-Token")
+	if channelID == "" || token == "" {
+		return http.StatusBadRequest, nil, errors.New("missing channel headers")
+	}
+
+	record, err := loadSubscriptionRecord(channelID)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	if record == nil {
+		return http.StatusNotFound, nil, errors.New("unknown subscription")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashClientState(token)), []byte(record.ClientStateHash)) != 1 {
+		return http.StatusForbidden, nil, errors.New("invalid channel token")
+	}
+
+	// Limited once verified, so webhooks forged for other channels don't use up the limit
+	if !webhookLimiter.allow(channelID, now) {
+		return http.StatusTooManyRequests, nil, errors.New("too many webhooks")
+	}
+
+	if resourceID := req.Header.Get("X-Goog-Resource-Id"); resourceID != "" && resourceID != record.ResourceID {
+		return http.StatusForbidden, nil, errors.New("invalid resource")
+	}
+
+	if record.ReplacedBy != "" {
+		// The changes it tells about are notified through the subscription renewing it
+		return http.StatusOK, nil, errors.New("renewed subscription")
+	}
+
+	if expiration := req.Header.Get("X-Goog-Channel-Expiration"); expiration != "" {
+		expiresAt, err := time.Parse(channelExpirationFormat, expiration)
+		if err == nil && expiresAt.Before(now) {
+			return http.StatusGone, nil, errors.New("expired subscription")
+		}
+	}
+
+	// Requests sent by the plugin itself aren't numbered
+	if req.Header.Get("X-Goog-Resource-State") == resourceStatePending {
+		return 0, record, nil
+	}
+
+	messageNumber, err := strconv.ParseInt(req.Header.Get("X-Goog-Message-Number"), 10, 64)
+	if err != nil {
+		return http.StatusBadRequest, nil, errors.New("invalid message number")
+	}
+
+	status, err := storeMessageNumber(channelID, messageNumber)
+	if status != 0 {
+		return status, nil, err
+	}
+
+	// Only used to list subscriptions, the webhook is handled even if it can't be stored
+	_ = kvSet(subscriptionKey(lastNotificationKeyPrefix, channelID), now.UnixMilli())
+
+	return 0, record, nil
+}
+
+// storeMessageNumber stores the number of the last message of the subscription, unless a message with the same or
+// a later number was handled. Webhooks are handled concurrently, so the number is only stored if it didn't change
+// since it was read. It returns the status code to answer with when the webhook must be dropped, or 0.
+func storeMessageNumber(channelID string, messageNumber int64) (int, error) {
+	key := subscriptionKey(subscriptionMessageKeyPrefix, channelID)
+	for {
+		var lastMessageNumber int64
+		found, err := kvGet(key, &lastMessageNumber)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if found && messageNumber <= lastMessageNumber {
+			// Already handled, the changes it tells about were synced then. Google must not send it again.
+			return http.StatusOK, errors.New("duplicated message")
+		}
+
+		var oldValue any
+		if found {
+			oldValue = lastMessageNumber
+		}
+		set, err := kvCompareAndSet(key, oldValue, messageNumber)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if set {
+			return 0, nil
+		}
+		// Another message was handled in the meantime, compare with its number
+	}
+}
+
+// renewRecommended returns true if the subscription expires soon
+func renewRecommended(record *subscriptionRecord, now time.Time) bool {
+	renewBefore := min(renewRecommendedBefore, getSubscriptionTTL()/2)
+	return record.Expiration > 0 && time.UnixMilli(record.Expiration).Sub(now) < renewBefore
+}
+
 func (r *impl) HandleWebhook(w http.ResponseWriter, req *http.Request) []*remote.Notification {
 	resourceState := req.Header.Get("X-Goog-Resource-State")
 	if resourceState == resourceStateSync {
@@ -37,17 +190,49 @@ func (r *impl) HandleWebhook(w http.ResponseWriter, req *http.Request) []*remote
 	resourceID := req.Header.Get("X-Goog-Resource-Id")
 	token := req.Header.Get("X-Goog-Channel-Token")
 
+	status, record, err := verifyWebhook(req, time.Now())
+	if status != 0 {
+		r.logger.With(bot.LogContext{
+			"subscriptionID": notificationChannelID,
+			"status":         status,
+		}).Debugf("gcal: dropped webhook. err=%v", err)
+		w.WriteHeader(status)
+		return []*remote.Notification{}
+	}
+
 	wh := &webhook{
 		SubscriptionID: notificationChannelID,
 		ClientState:    token,
 		Resource:       resourceID,
 	}
 
+	if resourceState == resourceStatePending {
+		notifications, err := takePendingNotifications(notificationChannelID, wh)
+		if err != nil {
+			r.logger.Warnf("gcal: error loading pending notifications. err=%v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return notifications
+	}
+
+	switch resourceState {
+	case resourceStateExists:
+		// The changed events are found when getting the notification data
+	case resourceStateNotExists:
+		// Only the primary calendar of the users is watched, which can't be deleted
+		w.WriteHeader(http.StatusAccepted)
+		return []*remote.Notification{}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return []*remote.Notification{}
+	}
+
 	n := &remote.Notification{
 		SubscriptionID: notificationChannelID,
 		// ChangeType:     wh.ChangeType, // not needed
-		ClientState: wh.ClientState,
-		IsBare:      true,
+		ClientState:    wh.ClientState,
+		IsBare:         true,
+		RecommendRenew: renewRecommended(record, time.Now()),
 		// WebhookRawData: rawData,
 		Webhook: wh,
 	}
@@ -55,5 +240,13 @@ func (r *impl) HandleWebhook(w http.ResponseWriter, req *http.Request) []*remote
 	w.WriteHeader(http.StatusAccepted)
 
 	notifications := []*remote.Notification{n}
+
+	// Notifications still pending when the last delivery failed go along with the new push
+	pending, err := takePendingNotifications(notificationChannelID, wh)
+	if err != nil {
+		r.logger.Warnf("gcal: error loading pending notifications. err=%v", err)
+	}
+	notifications = append(notifications, pending...)
+
 	return notifications
 }
@@ -0,0 +1,182 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookRateLimiter(t *testing.T) {
+	limiter := &webhookRateLimiter{}
+	now := time.Now()
+
+	for i := 0; i < webhookChannelRateLimit; i++ {
+		require.True(t, limiter.allow("channel_1", now))
+	}
+	require.False(t, limiter.allow("channel_1", now))
+	require.True(t, limiter.allow("channel_2", now))
+
+	require.True(t, limiter.allow("channel_1", now.Add(webhookRateLimitWindow)))
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	now := time.Now()
+	record, err := json.Marshal(&subscriptionRecord{
+		ID:              "channel_id",
+		ResourceID:      "resource_id",
+		ClientStateHash: hashClientState("token"),
+		Expiration:      now.Add(2 * time.Hour).UnixMilli(),
+	})
+	require.NoError(t, err)
+	replacedRecord, err := json.Marshal(&subscriptionRecord{
+		ID:              "replaced_channel_id",
+		ResourceID:      "resource_id",
+		ClientStateHash: hashClientState("token"),
+		Expiration:      now.Add(2 * time.Hour).UnixMilli(),
+		ReplacedBy:      "channel_id",
+	})
+	require.NoError(t, err)
+
+	newRequest := func(channelID, token, messageNumber string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+		req.Header.Set("X-Goog-Channel-Id", channelID)
+		req.Header.Set("X-Goog-Channel-Token", token)
+		req.Header.Set("X-Goog-Resource-Id", "resource_id")
+		req.Header.Set("X-Goog-Resource-State", resourceStateExists)
+		req.Header.Set("X-Goog-Message-Number", messageNumber)
+		req.Header.Set("X-Goog-Channel-Expiration", now.Add(2*time.Hour).UTC().Format(channelExpirationFormat))
+		return req
+	}
+
+	tcs := []struct {
+		Name           string
+		Request        *http.Request
+		LastMessage    string
+		ExpectedStatus int
+	}{
+		{
+			Name:           "valid webhook",
+			Request:        newRequest("channel_id", "token", "5"),
+			LastMessage:    "4",
+			ExpectedStatus: 0,
+		},
+		{
+			Name:           "missing headers",
+			Request:        newRequest("", "", "5"),
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Name:           "unknown channel",
+			Request:        newRequest("unknown_channel_id", "token", "5"),
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Name:           "invalid token",
+			Request:        newRequest("channel_id", "forged", "5"),
+			ExpectedStatus: http.StatusForbidden,
+		},
+		{
+			Name:           "replayed message",
+			Request:        newRequest("channel_id", "token", "4"),
+			LastMessage:    "4",
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name:           "renewed channel",
+			Request:        newRequest("replaced_channel_id", "token", "5"),
+			ExpectedStatus: http.StatusOK,
+		},
+		{
+			Name: "expired channel",
+			Request: func() *http.Request {
+				req := newRequest("channel_id", "token", "5")
+				req.Header.Set("X-Goog-Channel-Expiration", now.Add(-time.Hour).UTC().Format(channelExpirationFormat))
+				return req
+			}(),
+			ExpectedStatus: http.StatusGone,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("KVGet", subscriptionKey(subscriptionKeyPrefix, "channel_id")).Return(record, nil)
+			api.On("KVGet", subscriptionKey(subscriptionKeyPrefix, "unknown_channel_id")).Return(nil, nil)
+			api.On("KVGet", subscriptionKey(subscriptionKeyPrefix, "replaced_channel_id")).Return(replacedRecord, nil)
+			var lastMessage []byte
+			if tc.LastMessage != "" {
+				lastMessage = []byte(tc.LastMessage)
+			}
+			api.On("KVGet", subscriptionKey(subscriptionMessageKeyPrefix, "channel_id")).Return(lastMessage, nil)
+			api.On("KVCompareAndSet", subscriptionKey(subscriptionMessageKeyPrefix, "channel_id"), lastMessage, mock.Anything).Return(true, nil)
+			api.On("KVSet", subscriptionKey(lastNotificationKeyPrefix, "channel_id"), mock.Anything).Return(nil)
+			SetPluginAPI(api)
+
+			status, verified, err := verifyWebhook(tc.Request, now)
+			require.Equal(t, tc.ExpectedStatus, status)
+			if tc.ExpectedStatus == 0 {
+				require.NoError(t, err)
+				require.Equal(t, "channel_id", verified.ID)
+				require.True(t, renewRecommended(verified, now))
+				api.AssertCalled(t, "KVCompareAndSet", subscriptionKey(subscriptionMessageKeyPrefix, "channel_id"), []byte("4"), []byte("5"))
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestStoreMessageNumber(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	key := subscriptionKey(subscriptionMessageKeyPrefix, "channel_id")
+
+	t.Run("first message", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", key).Return(nil, nil)
+		api.On("KVCompareAndSet", key, []byte(nil), []byte("1")).Return(true, nil)
+		SetPluginAPI(api)
+
+		status, err := storeMessageNumber("channel_id", 1)
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+	})
+
+	t.Run("later message handled in the meantime", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", key).Return([]byte("4"), nil).Once()
+		api.On("KVCompareAndSet", key, []byte("4"), []byte("5")).Return(false, nil).Once()
+		api.On("KVGet", key).Return([]byte("6"), nil).Once()
+		SetPluginAPI(api)
+
+		status, err := storeMessageNumber("channel_id", 5)
+		require.Error(t, err)
+		require.Equal(t, http.StatusOK, status)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("earlier message handled in the meantime", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("KVGet", key).Return([]byte("4"), nil).Once()
+		api.On("KVCompareAndSet", key, []byte("4"), []byte("6")).Return(false, nil).Once()
+		api.On("KVGet", key).Return([]byte("5"), nil).Once()
+		api.On("KVCompareAndSet", key, []byte("5"), []byte("6")).Return(true, nil).Once()
+		SetPluginAPI(api)
+
+		status, err := storeMessageNumber("channel_id", 6)
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+		api.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+const (
+	workingLocationStatusKeyPrefix         = "gcal_working_location_status_"
+	workingLocationStatusDisabledKeyPrefix = "gcal_working_location_status_disabled_"
+
+	// workingLocationStatusSyncInterval is how often the working location of a user is checked
+	workingLocationStatusSyncInterval = 15 * time.Minute
+
+	workingLocationHomeOfficeStatus = "Working from home"
+	workingLocationOfficeStatus     = "Office"
+
+	workingLocationHomeOfficeEmoji = "house"
+	workingLocationOfficeEmoji     = "office"
+	workingLocationCustomEmoji     = "round_pushpin"
+
+	customStatusDurationDateAndTime = "date_and_time"
+)
+
+// workingLocationStatus is the custom status set on a user from their working location
+type workingLocationStatus struct {
+	// Text is the text of the custom status set by the plugin, empty if none is set
+	Text string `json:"text"`
+	// Previous is the custom status of the user before the plugin set one
+	Previous *model.CustomStatus `json:"previous"`
+	// Overridden is the working location status the user replaced with their own custom status
+	Overridden string `json:"overridden"`
+	// ExpiresAt is when the custom status set by the plugin expires, at the end of the working location
+	ExpiresAt int64 `json:"expires_at"`
+
+	syncSchedule
+}
+
+func (s *workingLocationStatus) schedule() *syncSchedule {
+	return &s.syncSchedule
+}
+
+// workingLocationStatusSync follows the working location of the users in their custom status
+var workingLocationStatusSync = &periodicSync{
+	name:              "working location status",
+	keyPrefix:         workingLocationStatusKeyPrefix,
+	disabledKeyPrefix: workingLocationStatusDisabledKeyPrefix,
+	interval:          workingLocationStatusSyncInterval,
+}
+
+// SetWorkingLocationStatusSync sets whether the custom status of a user follows their Google working location
+func SetWorkingLocationStatusSync(mattermostUserID string, enabled bool) error {
+	return workingLocationStatusSync.setEnabled(mattermostUserID, enabled)
+}
+
+// syncWorkingLocationStatus sets the custom status of the user from their current working location, and
+// restores their previous status once they have no working location anymore
+func (c *client) syncWorkingLocationStatus() {
+	runPeriodicSync(c, workingLocationStatusSync, func(state *workingLocationStatus) error {
+		event, err := c.getCurrentWorkingLocation()
+		if err != nil {
+			return err
+		}
+		return updateWorkingLocationStatus(c.mattermostUserID, state, event)
+	})
+}
+
+// getCurrentWorkingLocation returns the working location event of the user right now, or nil if they didn't set one
+func (c *client) getCurrentWorkingLocation() (*calendar.Event, error) {
+	events, err := c.getCurrentEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	// The narrowest location wins, like an afternoon at the office over a whole day at home
+	var current *calendar.Event
+	for _, event := range events {
+		if event.EventType != GoogleEventTypeWorkingLocation || event.WorkingLocationProperties == nil {
+			continue
+		}
+		if current == nil || eventDuration(event) < eventDuration(current) {
+			current = event
+		}
+	}
+
+	return current, nil
+}
+
+// getCurrentEvents returns the events of the primary calendar of the user taking place right now
+func (c *client) getCurrentEvents() ([]*calendar.Event, error) {
+	ctx := context.Background()
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(c.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating service")
+	}
+
+	now := time.Now()
+	events, err := c.listCachedEvents(ctx, service, defaultCalendarName, now, now.Add(time.Minute))
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting list of events")
+	}
+
+	return events, nil
+}
+
+func eventDuration(event *calendar.Event) time.Duration {
+	start, err := parseEventDateTime(event.Start)
+	if err != nil {
+		return 0
+	}
+	end, err := parseEventDateTime(event.End)
+	if err != nil {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// newWorkingLocationCustomStatus returns the custom status describing a working location, or nil if there is none
+func newWorkingLocationCustomStatus(properties *calendar.EventWorkingLocationProperties) *model.CustomStatus {
+	if properties == nil {
+		return nil
+	}
+
+	switch properties.Type {
+	case workingLocationHomeOffice:
+		return &model.CustomStatus{
+			Emoji: workingLocationHomeOfficeEmoji,
+			Text:  workingLocationHomeOfficeStatus,
+		}
+	case workingLocationOffice:
+		text := workingLocationOfficeStatus
+		if office := properties.OfficeLocation; office != nil {
+			details := []string{}
+			if office.Label != "" {
+				details = append(details, office.Label)
+			}
+			if office.FloorId != "" {
+				details = append(details, "floor "+office.FloorId)
+			}
+			if office.DeskId != "" {
+				details = append(details, "desk "+office.DeskId)
+			}
+			if len(details) > 0 {
+				text += " – " + strings.Join(details, ", ")
+			}
+		}
+		return &model.CustomStatus{
+			Emoji: workingLocationOfficeEmoji,
+			Text:  text,
+		}
+	case workingLocationCustom:
+		if properties.CustomLocation == nil || properties.CustomLocation.Label == "" {
+			return nil
+		}
+		return &model.CustomStatus{
+			Emoji: workingLocationCustomEmoji,
+			Text:  properties.CustomLocation.Label,
+		}
+	}
+
+	return nil
+}
+
+// updateWorkingLocationStatus sets the status of the working location event of the user, keeping track of the
+// status it replaces. Statuses set by the user in the meantime are left alone.
+func updateWorkingLocationStatus(mattermostUserID string, state *workingLocationStatus, event *calendar.Event) error {
+	user, appErr := pluginAPI.GetUser(mattermostUserID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "error getting user")
+	}
+	current := user.GetCustomStatus()
+
+	var status *model.CustomStatus
+	if event != nil {
+		status = newWorkingLocationCustomStatus(event.WorkingLocationProperties)
+	}
+	if status != nil {
+		expiresAt, err := workingLocationStatusExpiry(event, userLocation(user))
+		if err != nil {
+			return err
+		}
+		status.Duration = customStatusDurationDateAndTime
+		status.ExpiresAt = expiresAt
+	}
+
+	if state.Text != "" && (current == nil || current.Text != state.Text) {
+		if current == nil && state.ExpiresAt > 0 && !time.Now().Before(time.UnixMilli(state.ExpiresAt)) {
+			// The working location status expired, the previous status is kept to be restored
+			state.Text = ""
+		} else {
+			// The user replaced the working location status with their own
+			state.Overridden = state.Text
+			state.Text = ""
+			state.Previous = nil
+		}
+		state.ExpiresAt = 0
+	}
+
+	if status == nil {
+		state.Overridden = ""
+		if state.Text == "" && state.Previous == nil {
+			return nil
+		}
+
+		err := restoreCustomStatus(mattermostUserID, state.Previous)
+		if err != nil {
+			return err
+		}
+		state.Text = ""
+		state.Previous = nil
+		state.ExpiresAt = 0
+		return nil
+	}
+
+	if (status.Text == state.Text && status.ExpiresAt.UnixMilli() == state.ExpiresAt) || status.Text == state.Overridden {
+		return nil
+	}
+	state.Overridden = ""
+
+	if state.Text == "" && state.Previous == nil {
+		state.Previous = current
+	}
+
+	appErr = pluginAPI.UpdateUserCustomStatus(mattermostUserID, status)
+	if appErr != nil {
+		return errors.Wrap(appErr, "error setting custom status")
+	}
+	state.Text = status.Text
+	state.ExpiresAt = status.ExpiresAt.UnixMilli()
+
+	return nil
+}
+
+// workingLocationStatusExpiry returns when the status of a working location expires: at the end of the event, or at
+// the end of its last day in the time zone of the user for all-day events
+func workingLocationStatusExpiry(event *calendar.Event, loc *time.Location) (time.Time, error) {
+	if event.End == nil {
+		return time.Time{}, errors.New("the working location has no end")
+	}
+
+	if event.End.Date != "" {
+		// The end date is exclusive, so the status expires as it starts
+		expiresAt, err := time.ParseInLocation(time.DateOnly, event.End.Date, loc)
+		return expiresAt, errors.Wrap(err, "error parsing working location end")
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, event.End.DateTime)
+	return expiresAt, errors.Wrap(err, "error parsing working location end")
+}
+
+// userLocation returns the time zone of the user, UTC if they didn't set one
+func userLocation(user *model.User) *time.Location {
+	loc, err := time.LoadLocation(user.GetPreferredTimezone())
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// restoreCustomStatus sets back the status the user had before their working location status, if it
+// hasn't expired in the meantime
+func restoreCustomStatus(mattermostUserID string, previous *model.CustomStatus) error {
+	if previous == nil || !previous.AreDurationAndExpirationTimeValid() {
+		appErr := pluginAPI.RemoveUserCustomStatus(mattermostUserID)
+		if appErr != nil {
+			return errors.Wrap(appErr, "error removing custom status")
+		}
+		return nil
+	}
+
+	appErr := pluginAPI.UpdateUserCustomStatus(mattermostUserID, previous)
+	if appErr != nil {
+		return errors.Wrap(appErr, "error restoring custom status")
+	}
+	return nil
+}
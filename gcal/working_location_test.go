@@ -0,0 +1,241 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package gcal
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestNewWorkingLocationCustomStatus(t *testing.T) {
+	tcs := []struct {
+		Name       string
+		Properties *calendar.EventWorkingLocationProperties
+		Expected   *model.CustomStatus
+	}{
+		{
+			Name:       "no working location",
+			Properties: nil,
+			Expected:   nil,
+		},
+		{
+			Name: "home",
+			Properties: &calendar.EventWorkingLocationProperties{
+				Type:       workingLocationHomeOffice,
+				HomeOffice: map[string]any{},
+			},
+			Expected: &model.CustomStatus{Emoji: workingLocationHomeOfficeEmoji, Text: "Working from home"},
+		},
+		{
+			Name: "office with details",
+			Properties: &calendar.EventWorkingLocationProperties{
+				Type: workingLocationOffice,
+				OfficeLocation: &calendar.EventWorkingLocationPropertiesOfficeLocation{
+					Label:   "Berlin",
+					FloorId: "3",
+				},
+			},
+			Expected: &model.CustomStatus{Emoji: workingLocationOfficeEmoji, Text: "Office – Berlin, floor 3"},
+		},
+		{
+			Name: "office without details",
+			Properties: &calendar.EventWorkingLocationProperties{
+				Type: workingLocationOffice,
+			},
+			Expected: &model.CustomStatus{Emoji: workingLocationOfficeEmoji, Text: "Office"},
+		},
+		{
+			Name: "custom location",
+			Properties: &calendar.EventWorkingLocationProperties{
+				Type: workingLocationCustom,
+				CustomLocation: &calendar.EventWorkingLocationPropertiesCustomLocation{
+					Label: "Client site",
+				},
+			},
+			Expected: &model.CustomStatus{Emoji: workingLocationCustomEmoji, Text: "Client site"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Expected, newWorkingLocationCustomStatus(tc.Properties))
+		})
+	}
+}
+
+func TestUpdateWorkingLocationStatus(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	userWithStatus := func(status *model.CustomStatus) *model.User {
+		user := &model.User{Id: "user_id", Timezone: model.StringMap{"useAutomaticTimezone": "false", "manualTimezone": "Europe/Berlin"}}
+		if status != nil {
+			require.NoError(t, user.SetCustomStatus(status))
+		}
+		return user
+	}
+
+	end := time.Now().Add(time.Hour).Truncate(time.Second)
+	homeEvent := &calendar.Event{
+		EventType: GoogleEventTypeWorkingLocation,
+		End:       &calendar.EventDateTime{DateTime: end.Format(time.RFC3339)},
+		WorkingLocationProperties: &calendar.EventWorkingLocationProperties{
+			Type:       workingLocationHomeOffice,
+			HomeOffice: map[string]any{},
+		},
+	}
+
+	previous := &model.CustomStatus{Emoji: "coffee", Text: "Coffee break"}
+	home := &model.CustomStatus{
+		Emoji:     workingLocationHomeOfficeEmoji,
+		Text:      workingLocationHomeOfficeStatus,
+		Duration:  customStatusDurationDateAndTime,
+		ExpiresAt: end,
+	}
+	isHome := mock.MatchedBy(func(status *model.CustomStatus) bool {
+		return status.Text == home.Text && status.Duration == home.Duration && status.ExpiresAt.Equal(end)
+	})
+
+	t.Run("status is set until the end of the working location and the previous one remembered", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", "user_id").Return(userWithStatus(previous), nil)
+		api.On("UpdateUserCustomStatus", "user_id", isHome).Return(nil)
+		SetPluginAPI(api)
+
+		state := &workingLocationStatus{}
+		require.NoError(t, updateWorkingLocationStatus("user_id", state, homeEvent))
+		require.Equal(t, home.Text, state.Text)
+		require.Equal(t, end.UnixMilli(), state.ExpiresAt)
+		require.Equal(t, previous.Text, state.Previous.Text)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("previous status is restored", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", "user_id").Return(userWithStatus(home), nil)
+		api.On("UpdateUserCustomStatus", "user_id", mock.MatchedBy(func(status *model.CustomStatus) bool {
+			return status.Text == previous.Text
+		})).Return(nil)
+		SetPluginAPI(api)
+
+		state := &workingLocationStatus{Text: home.Text, Previous: previous, ExpiresAt: end.UnixMilli()}
+		require.NoError(t, updateWorkingLocationStatus("user_id", state, nil))
+		require.Empty(t, state.Text)
+		require.Nil(t, state.Previous)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("status is set again after expiring", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", "user_id").Return(userWithStatus(nil), nil)
+		api.On("UpdateUserCustomStatus", "user_id", isHome).Return(nil)
+		SetPluginAPI(api)
+
+		state := &workingLocationStatus{Text: home.Text, Previous: previous, ExpiresAt: time.Now().Add(-time.Hour).UnixMilli()}
+		require.NoError(t, updateWorkingLocationStatus("user_id", state, homeEvent))
+		require.Equal(t, home.Text, state.Text)
+		require.Empty(t, state.Overridden)
+		require.Equal(t, previous.Text, state.Previous.Text)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("status set by the user is kept", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUser", "user_id").Return(userWithStatus(previous), nil)
+		SetPluginAPI(api)
+
+		state := &workingLocationStatus{Text: home.Text, ExpiresAt: end.UnixMilli()}
+		require.NoError(t, updateWorkingLocationStatus("user_id", state, homeEvent))
+		require.Empty(t, state.Text)
+		require.Equal(t, home.Text, state.Overridden)
+		api.AssertNotCalled(t, "UpdateUserCustomStatus", mock.Anything, mock.Anything)
+
+		require.NoError(t, updateWorkingLocationStatus("user_id", state, nil))
+		require.Empty(t, state.Overridden)
+		api.AssertNotCalled(t, "RemoveUserCustomStatus", mock.Anything)
+	})
+}
+
+func TestGetCurrentWorkingLocation(t *testing.T) {
+	defer SetPluginAPI(nil)
+
+	newWorkingLocation := func(id string, day time.Time) *calendar.Event {
+		return &calendar.Event{
+			Id:        id,
+			Status:    "confirmed",
+			EventType: GoogleEventTypeWorkingLocation,
+			Start:     &calendar.EventDateTime{Date: day.Format(time.DateOnly)},
+			End:       &calendar.EventDateTime{Date: day.AddDate(0, 0, 1).Format(time.DateOnly)},
+			WorkingLocationProperties: &calendar.EventWorkingLocationProperties{
+				Type:       workingLocationHomeOffice,
+				HomeOffice: map[string]any{},
+			},
+		}
+	}
+
+	// Calendars far from UTC, where the day of the calendar is rarely the UTC day
+	for _, timeZone := range []string{"Pacific/Kiritimati", "Pacific/Pago_Pago"} {
+		t.Run(timeZone, func(t *testing.T) {
+			loc, err := time.LoadLocation(timeZone)
+			require.NoError(t, err)
+
+			now := time.Now()
+			today := now.In(loc)
+			cache := &eventCache{
+				SyncToken:   "token",
+				WindowStart: now.Add(-eventCacheLookbehind).UnixMilli(),
+				WindowEnd:   now.Add(eventCacheLookahead).UnixMilli(),
+				TimeZone:    timeZone,
+				Events:      map[string]*calendar.Event{},
+			}
+			cache.apply([]*calendar.Event{
+				newWorkingLocation("yesterday", today.AddDate(0, 0, -1)),
+				newWorkingLocation("today", today),
+				newWorkingLocation("tomorrow", today.AddDate(0, 0, 1)),
+			})
+			cacheData, err := json.Marshal(cache)
+			require.NoError(t, err)
+			syncedData, err := json.Marshal(now.UnixMilli())
+			require.NoError(t, err)
+
+			api := &plugintest.API{}
+			api.On("KVGet", eventCacheKey(eventCacheKeyPrefix, "user", defaultCalendarName)).Return(cacheData, nil)
+			api.On("KVGet", eventCacheKey(eventCacheSyncedKeyPrefix, "user", defaultCalendarName)).Return(syncedData, nil)
+			SetPluginAPI(api)
+
+			c := &client{httpClient: &http.Client{}, mattermostUserID: "user"}
+			event, err := c.getCurrentWorkingLocation()
+			require.NoError(t, err)
+			require.NotNil(t, event)
+			require.Equal(t, "today", event.Id)
+		})
+	}
+}
+
+func TestWorkingLocationStatusExpiry(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	expiresAt, err := workingLocationStatusExpiry(&calendar.Event{
+		End: &calendar.EventDateTime{DateTime: "2024-05-02T17:00:00+02:00"},
+	}, time.UTC)
+	require.NoError(t, err)
+	require.True(t, expiresAt.Equal(time.Date(2024, 5, 2, 15, 0, 0, 0, time.UTC)))
+
+	expiresAt, err = workingLocationStatusExpiry(&calendar.Event{
+		End: &calendar.EventDateTime{Date: "2024-05-03"},
+	}, berlin)
+	require.NoError(t, err)
+	require.True(t, expiresAt.Equal(time.Date(2024, 5, 3, 0, 0, 0, 0, berlin)))
+
+	_, err = workingLocationStatusExpiry(&calendar.Event{}, berlin)
+	require.Error(t, err)
+}
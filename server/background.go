@@ -0,0 +1,67 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/engine"
+
+	"github.com/mattermost/mattermost-plugin-google-calendar/gcal"
+)
+
+const (
+	backgroundSyncJobKey = "gcal_background_sync"
+
+	// backgroundSyncInterval is how often the connected users are checked for periodic work that is due
+	backgroundSyncInterval = 5 * time.Minute
+)
+
+// scheduleBackgroundSync starts the job running the periodic work of the connected users, once across the cluster
+func (p *Plugin) scheduleBackgroundSync() error {
+	job, err := cluster.Schedule(p.API, backgroundSyncJobKey, cluster.MakeWaitForRoundedInterval(backgroundSyncInterval), p.runBackgroundSync)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule the background sync")
+	}
+
+	p.backgroundSyncJob = job
+	return nil
+}
+
+// runBackgroundSync shares the channel calendars, and follows the working location and out of office events of the
+// connected users. Users are skipped until their work is due, and backed off when their client can't be made.
+func (p *Plugin) runBackgroundSync() {
+	if p.env.Store == nil {
+		return
+	}
+
+	users, err := p.env.Store.LoadUserIndex()
+	if err != nil {
+		p.API.LogWarn("Failed to load the connected users", "err", err.Error())
+		return
+	}
+
+	now := time.Now()
+	for _, user := range users {
+		due, err := gcal.IsBackgroundSyncDue(user.MattermostUserID, now)
+		if err != nil || !due {
+			continue
+		}
+
+		client, err := engine.New(p.env, user.MattermostUserID).MakeClient()
+		if err == nil {
+			err = gcal.SyncBackground(client)
+		}
+		if err != nil {
+			p.API.LogDebug("Failed to sync user in the background", "user_id", user.MattermostUserID, "err", err.Error())
+			err = gcal.DelayBackgroundSync(user.MattermostUserID)
+			if err != nil {
+				p.API.LogWarn("Failed to delay the background sync", "user_id", user.MattermostUserID, "err", err.Error())
+			}
+		}
+	}
+}
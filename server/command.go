@@ -0,0 +1,828 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	mattermostplugin "github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/engine"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+
+	"github.com/mattermost/mattermost-plugin-google-calendar/gcal"
+)
+
+const (
+	eventCommandHelp = "###### Manage your Google Calendar events\n" +
+		"- `/gcal event create <start> <end> <title> [--calendar=<calendar ID>] [--meet|--call] [--reminders=<offsets>|none] [--repeat=daily|weekly|monthly|yearly] [--count=<occurrences>] [--until=<day>]` - Create an event on your primary calendar, or on the calendar given with `--calendar`. Start and end are times like `2024-05-02T15:04` or days like `2024-05-02`, in your time zone. With `--meet`, a Google Meet conference is added to the event. With `--call`, the event links to a Mattermost call in this channel. With `--reminders`, like `--reminders=10m,1h`, you're reminded that long before the event instead of at the default times of the calendar, or never with `--reminders=none`. With `--repeat`, the event repeats until the day given with `--until`, for the number of occurrences given with `--count`, or forever.\n" +
+		"- `/gcal event reschedule <event link or ID> <new start>` - Move an event, keeping its duration. The new start is either an offset like `+30m` or `-1h`, or a time like `2024-05-02T15:04` in the time zone of the event, or in your time zone if the event has none.\n" +
+		"- `/gcal event rename <event link or ID> <new title>` - Change the title of an event.\n" +
+		"- `/gcal event cancel <event link or ID> [notify-channel]` - Cancel an event you organize, or remove an event you were invited to from your calendar. With `notify-channel`, the cancellation is also posted to the channel the calendar of the event is linked to.\n" +
+		"\nAttendees are notified of the changes by Google. Changes to an occurrence of a recurring event only apply to that occurrence, unless `--scope=following` or `--scope=all` is added to also change the following occurrences or the whole series."
+
+	recurrenceScopeFlag = "--scope="
+	flagPrefix          = "--"
+
+	timeBlockCommandHelp = "###### Block time on your calendar\n" +
+		"- `/gcal focus <start> <end> [title] [--decline=all|new|none] [--status=dnd|available]` - Block time for focus. With `--status`, your Google Chat status is set to do not disturb or left available during that time.\n" +
+		"- `/gcal ooo <start> <end> [decline message] [--decline=all|new|none]` - Block time out of office. Invitations are declined with the message.\n" +
+		"- `/gcal ooo autoresponder on|off|message [message]` - Choose whether and how your auto-responder replies during your out of office events.\n" +
+		"\nStart and end are times like `2024-05-02T15:04` or days like `2024-05-02`, in your time zone. A day as the end includes the whole day. " +
+		"New invitations during that time are declined, unless `--decline=all` is added to also decline the meetings you already accepted, or `--decline=none` to decline nothing."
+
+	autoResponderCommandHelp = "###### Reply automatically while you are out of office\n" +
+		"- `/gcal ooo autoresponder on` - Turn on your auto-responder during your out of office events.\n" +
+		"- `/gcal ooo autoresponder off` - Stop turning on your auto-responder during your out of office events.\n" +
+		"- `/gcal ooo autoresponder message [message]` - Set the reply used when the out of office event has no decline message. `{end}` is replaced with the end of the event. Without a message, the default reply is used again."
+
+	remindersCommandHelp = "###### Choose when you get event reminders\n" +
+		"- `/gcal reminders follow` - Get reminded at the times set on each of your Google Calendar events.\n" +
+		"- `/gcal reminders default` - Get reminded at the default time, whatever the reminders set on your events."
+
+	workingLocationCommandHelp = "###### Share your working location\n" +
+		"- `/gcal working-location on` - Show the working location you set in Google Calendar in your custom status.\n" +
+		"- `/gcal working-location off` - Stop changing your custom status from your working location."
+
+	channelCalendarCommandHelp = "###### Give this channel its own calendar\n" +
+		"- `/gcal channel-calendar create [reader|writer]` - Create a Google calendar for this channel. It is shared with the channel members who connected their Google account, as readers unless `writer` is given.\n" +
+		"- `/gcal channel-calendar link <calendar ID> [reader|writer]` - Make one of your Google calendars the calendar of this channel, shared the same way. Run it again to change the role of the members.\n" +
+		"- `/gcal channel-calendar unlink` - Stop sharing the calendar with the channel members. The calendar is kept in Google.\n" +
+		"\nOnly channel admins can manage the calendar of a channel. A created calendar is deleted when the channel is archived."
+
+	subscriptionsCommandHeader = "###### Event notification subscriptions\n" +
+		"| User | Calendar | Health | Expires | Last notification |\n" +
+		"| --- | --- | --- | --- | --- |\n"
+
+	commandEventTimeFormat = "2006-01-02T15:04"
+	eventStartFormat       = "Monday, January 2 at 3:04 PM MST"
+	subscriptionTimeFormat = "2006-01-02 15:04 MST"
+)
+
+// gcalClient are the Google specific features of the provider client the commands use
+type gcalClient interface {
+	CreateEventWithOptions(in *remote.Event, opts *gcal.EventOptions) (*remote.Event, error)
+	GetEventConference(eventID string) (*gcal.Conference, error)
+	GetEventDetails(eventID string) (*gcal.Event, error)
+	UpdateEvent(eventID string, in *remote.Event, notifyAttendees bool) (*remote.Event, error)
+	RescheduleEvent(eventID string, start time.Time, notifyAttendees bool) (*remote.Event, error)
+	CancelEvent(eventID string, notifyAttendees, notifyChannel bool) error
+	UpdateRecurringEvent(eventID string, in *remote.Event, scope string, notifyAttendees bool) (*remote.Event, error)
+	RescheduleRecurringEvent(eventID string, start time.Time, scope string, notifyAttendees bool) (*remote.Event, error)
+	CancelRecurringEvent(eventID, scope string, notifyAttendees, notifyChannel bool) error
+	CreateFocusTimeEvent(subject string, start, end *remote.DateTime, focusTime *gcal.FocusTime) (*remote.Event, error)
+	CreateOutOfOfficeEvent(subject string, start, end *remote.DateTime, outOfOffice *gcal.OutOfOffice) (*remote.Event, error)
+	CreateChannelCalendar(channelID, channelDisplayName, channelURL, role string) (*remote.Calendar, error)
+	LinkChannelCalendar(channelID, calendarID, role string) error
+	UnlinkChannelCalendar(channelID string) error
+}
+
+// commandHandlers are the subcommands of the engine command handled by the provider, as the engine has no room
+// for Google specific features. The rest of the subcommands are passed on to the engine.
+var commandHandlers = map[string]func(p *Plugin, args *model.CommandArgs, parameters ...string) (string, error){
+	"channel-calendar": (*Plugin).executeChannelCalendarCommand,
+	"event":            (*Plugin).executeEventCommand,
+	"focus":            (*Plugin).executeFocusCommand,
+	"ooo":              (*Plugin).executeOutOfOfficeCommand,
+	"reminders":        (*Plugin).executeRemindersCommand,
+	"subscriptions":    (*Plugin).executeSubscriptionsCommand,
+	"working-location": (*Plugin).executeWorkingLocationCommand,
+}
+
+func (p *Plugin) ExecuteCommand(c *mattermostplugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	fields := strings.Fields(args.Command)
+	if len(fields) < 2 {
+		return p.Plugin.ExecuteCommand(c, args)
+	}
+	handler, ok := commandHandlers[fields[1]]
+	if !ok {
+		return p.Plugin.ExecuteCommand(c, args)
+	}
+
+	text, err := handler(p, args, fields[2:]...)
+	if err != nil {
+		text = fmt.Sprintf("Command %s failed: %s", strings.Join(fields[:2], " "), err.Error())
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}, nil
+}
+
+// makeEngineGcalClient returns the client of a user connected to Google, made by the engine
+func (p *Plugin) makeEngineGcalClient(mattermostUserID string) (gcalClient, error) {
+	client, err := engine.New(p.env, mattermostUserID).MakeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := client.(gcalClient)
+	if !ok {
+		return nil, errors.New("your account is not connected to Google Calendar")
+	}
+	return c, nil
+}
+
+func (p *Plugin) executeEventCommand(args *model.CommandArgs, parameters ...string) (string, error) {
+	if len(parameters) > 0 && parameters[0] == "create" {
+		return p.executeEventCreateCommand(args, parameters[1:]...)
+	}
+
+	parameters, scope, err := parseRecurrenceScope(parameters)
+	if err != nil {
+		return "", err
+	}
+	if len(parameters) < 2 {
+		return eventCommandHelp, nil
+	}
+
+	c, err := p.makeGcalClient(args.UserId)
+	if err != nil {
+		return "", err
+	}
+
+	event, err := c.GetEventDetails(gcal.EventIDFromLink(parameters[1]))
+	if err != nil {
+		return "", err
+	}
+	if !event.IsOccurrence() {
+		scope = gcal.RecurrenceScopeThis
+	}
+
+	var text string
+	switch parameters[0] {
+	case "reschedule":
+		if len(parameters) < 3 {
+			return eventCommandHelp, nil
+		}
+		loc, err := p.getEventLocation(args.UserId, event)
+		if err != nil {
+			return "", err
+		}
+		start, err := parseEventStart(event.Start.Time(), parameters[2], loc)
+		if err != nil {
+			return "", err
+		}
+
+		var result *remote.Event
+		if scope == gcal.RecurrenceScopeThis {
+			result, err = c.RescheduleEvent(event.ID, start, true)
+		} else {
+			result, err = c.RescheduleRecurringEvent(event.ID, start, scope, true)
+		}
+		if err != nil {
+			return "", err
+		}
+		text = fmt.Sprintf("The event **%s** was moved to %s.", result.Subject, result.Start.Time().In(loc).Format(eventStartFormat))
+
+	case "rename":
+		if len(parameters) < 3 {
+			return eventCommandHelp, nil
+		}
+		in := &remote.Event{Subject: strings.Join(parameters[2:], " ")}
+
+		var result *remote.Event
+		if scope == gcal.RecurrenceScopeThis {
+			result, err = c.UpdateEvent(event.ID, in, true)
+		} else {
+			result, err = c.UpdateRecurringEvent(event.ID, in, scope, true)
+		}
+		if err != nil {
+			return "", err
+		}
+		text = fmt.Sprintf("The event was renamed to **%s**.", result.Subject)
+
+	case "cancel":
+		notifyChannel := len(parameters) > 2 && parameters[2] == "notify-channel"
+		if scope == gcal.RecurrenceScopeThis {
+			err = c.CancelEvent(event.ID, true, notifyChannel)
+		} else {
+			err = c.CancelRecurringEvent(event.ID, scope, true, notifyChannel)
+		}
+		if err != nil {
+			return "", err
+		}
+		text = "The event was cancelled."
+
+	default:
+		return eventCommandHelp, nil
+	}
+
+	if event.IsOccurrence() && scope == gcal.RecurrenceScopeThis {
+		text += " Only this occurrence was changed, add `--scope=following` or `--scope=all` to change the other occurrences."
+	}
+	return text, nil
+}
+
+// executeEventCreateCommand creates an event on the calendar of the user, with the Google specific options
+// given as flags
+func (p *Plugin) executeEventCreateCommand(args *model.CommandArgs, parameters ...string) (string, error) {
+	parameters, flags, err := parseFlags(parameters, "calendar", "meet", "call", "reminders", "repeat", "count", "until")
+	if err != nil {
+		return "", err
+	}
+	if len(parameters) < 3 {
+		return eventCommandHelp, nil
+	}
+
+	timeZone, loc, err := p.getUserLocation(args.UserId)
+	if err != nil {
+		return "", err
+	}
+	start, end, err := parseTimeBlock(timeZone, loc, parameters[0], parameters[1])
+	if err != nil {
+		return "", err
+	}
+
+	_, addGoogleMeet := flags["meet"]
+	opts := &gcal.EventOptions{
+		CalendarID:    flags["calendar"],
+		AddGoogleMeet: addGoogleMeet,
+	}
+	opts.Recurrence, err = parseRecurrence(flags, loc)
+	if err != nil {
+		return "", err
+	}
+
+	if value, ok := flags["reminders"]; ok {
+		opts.ReminderMinutes, err = parseReminderMinutes(value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if _, ok := flags["call"]; ok {
+		channel, appErr := p.API.GetChannel(args.ChannelId)
+		if appErr != nil {
+			return "", errors.Wrap(appErr, "failed to get channel")
+		}
+		opts.MattermostCallURL, err = p.getChannelURL(args, channel)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	c, err := p.makeGcalClient(args.UserId)
+	if err != nil {
+		return "", err
+	}
+
+	event, err := c.CreateEventWithOptions(&remote.Event{
+		Subject: strings.Join(parameters[2:], " "),
+		Start:   start,
+		End:     end,
+	}, opts)
+	if err != nil {
+		return "", err
+	}
+
+	text := fmt.Sprintf("**%s** was added to your calendar on %s.", event.Subject, start.Time().Format(eventStartFormat))
+	if opts.Recurrence != nil {
+		text += fmt.Sprintf(" It repeats %s.", strings.ToLower(opts.Recurrence.Frequency))
+	}
+	if opts.AddGoogleMeet {
+		if event.Conference != nil {
+			text += fmt.Sprintf(" Join with Google Meet: %s", event.Conference.URL)
+			text += p.getDialInText(c, event.ID)
+		} else {
+			text += " Google is still creating its Google Meet conference."
+		}
+	}
+	if opts.MattermostCallURL != "" {
+		text += " The call is held in this channel."
+	}
+	return text, nil
+}
+
+// parseRecurrence returns how a created event repeats from the --repeat, --count and --until flags, or nil if
+// it doesn't repeat. The event repeats until the end of the --until day, in the time zone of the user.
+func parseRecurrence(flags map[string]string, loc *time.Location) (*gcal.Recurrence, error) {
+	frequency, ok := flags["repeat"]
+	if !ok {
+		if _, ok = flags["count"]; ok {
+			return nil, errors.New("--count is only valid with --repeat")
+		}
+		if _, ok = flags["until"]; ok {
+			return nil, errors.New("--until is only valid with --repeat")
+		}
+		return nil, nil
+	}
+
+	recurrence := &gcal.Recurrence{Frequency: strings.ToUpper(frequency)}
+	switch recurrence.Frequency {
+	case gcal.RecurrenceDaily, gcal.RecurrenceWeekly, gcal.RecurrenceMonthly, gcal.RecurrenceYearly:
+	default:
+		return nil, errors.Errorf("invalid repeat %q, expected one of daily, weekly, monthly or yearly", frequency)
+	}
+
+	if value, ok := flags["count"]; ok {
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 1 {
+			return nil, errors.Errorf("invalid count %q, expected a number of occurrences", value)
+		}
+		recurrence.Count = count
+	}
+
+	if value, ok := flags["until"]; ok {
+		until, err := time.ParseInLocation(time.DateOnly, value, loc)
+		if err != nil {
+			return nil, errors.Errorf("invalid until %q, expected a day like %s", value, time.DateOnly)
+		}
+		recurrence.Until = until.AddDate(0, 0, 1).Add(-time.Second)
+	}
+
+	return recurrence, recurrence.Validate()
+}
+
+// parseReminderMinutes parses reminder offsets like 10m,1h into minutes before the start of an event. none
+// removes all the reminders.
+func parseReminderMinutes(value string) ([]int, error) {
+	minutes := []int{}
+	if value == "none" {
+		return minutes, nil
+	}
+
+	for _, offset := range strings.Split(value, ",") {
+		d, err := time.ParseDuration(offset)
+		if err != nil || d < 0 || d%time.Minute != 0 {
+			return nil, errors.Errorf("invalid reminder %q, expected an offset in minutes or hours like 10m or 1h", offset)
+		}
+		minutes = append(minutes, int(d/time.Minute))
+	}
+	return minutes, nil
+}
+
+// parseFlags returns the parameters without the flags like --name=value, and the values of the flags. A flag
+// given without a value, like --name, is set to an empty value.
+func parseFlags(parameters []string, names ...string) ([]string, map[string]string, error) {
+	rest := []string{}
+	flags := map[string]string{}
+	for _, parameter := range parameters {
+		flag, ok := strings.CutPrefix(parameter, flagPrefix)
+		if !ok {
+			rest = append(rest, parameter)
+			continue
+		}
+
+		name, value, _ := strings.Cut(flag, "=")
+		if !slices.Contains(names, name) {
+			return nil, nil, errors.Errorf("unknown flag %q", flagPrefix+name)
+		}
+		flags[name] = value
+	}
+	return rest, flags, nil
+}
+
+// parseRecurrenceScope returns the parameters without the recurrence scope flag, and the scope it sets
+func parseRecurrenceScope(parameters []string) ([]string, string, error) {
+	scope := gcal.RecurrenceScopeThis
+	rest := []string{}
+	for _, parameter := range parameters {
+		value, ok := strings.CutPrefix(parameter, recurrenceScopeFlag)
+		if !ok {
+			rest = append(rest, parameter)
+			continue
+		}
+
+		switch value {
+		case gcal.RecurrenceScopeThis, gcal.RecurrenceScopeFollowing, gcal.RecurrenceScopeAll:
+			scope = value
+		default:
+			return nil, "", errors.Errorf("invalid scope %q, expected one of this, following or all", value)
+		}
+	}
+	return rest, scope, nil
+}
+
+func (p *Plugin) executeFocusCommand(args *model.CommandArgs, parameters ...string) (string, error) {
+	parameters, flags, err := parseFlags(parameters, "decline", "status")
+	if err != nil {
+		return "", err
+	}
+	if len(parameters) < 2 {
+		return timeBlockCommandHelp, nil
+	}
+
+	autoDeclineMode, err := parseAutoDeclineMode(flags)
+	if err != nil {
+		return "", err
+	}
+	chatStatus, err := parseChatStatus(flags)
+	if err != nil {
+		return "", err
+	}
+
+	timeZone, loc, err := p.getUserLocation(args.UserId)
+	if err != nil {
+		return "", err
+	}
+	start, end, err := parseTimeBlock(timeZone, loc, parameters[0], parameters[1])
+	if err != nil {
+		return "", err
+	}
+
+	c, err := p.makeGcalClient(args.UserId)
+	if err != nil {
+		return "", err
+	}
+
+	event, err := c.CreateFocusTimeEvent(strings.Join(parameters[2:], " "), start, end, &gcal.FocusTime{
+		AutoDeclineMode: autoDeclineMode,
+		ChatStatus:      chatStatus,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("**%s** was added to your calendar from %s to %s.", event.Subject,
+		event.Start.Time().In(loc).Format(eventStartFormat), event.End.Time().In(loc).Format(eventStartFormat)), nil
+}
+
+func (p *Plugin) executeOutOfOfficeCommand(args *model.CommandArgs, parameters ...string) (string, error) {
+	if len(parameters) > 0 && parameters[0] == "autoresponder" {
+		return p.executeAutoResponderCommand(args, parameters[1:]...)
+	}
+
+	parameters, flags, err := parseFlags(parameters, "decline")
+	if err != nil {
+		return "", err
+	}
+	if len(parameters) < 2 {
+		return timeBlockCommandHelp, nil
+	}
+
+	autoDeclineMode, err := parseAutoDeclineMode(flags)
+	if err != nil {
+		return "", err
+	}
+
+	timeZone, loc, err := p.getUserLocation(args.UserId)
+	if err != nil {
+		return "", err
+	}
+	start, end, err := parseTimeBlock(timeZone, loc, parameters[0], parameters[1])
+	if err != nil {
+		return "", err
+	}
+
+	c, err := p.makeGcalClient(args.UserId)
+	if err != nil {
+		return "", err
+	}
+
+	event, err := c.CreateOutOfOfficeEvent("", start, end, &gcal.OutOfOffice{
+		AutoDeclineMode: autoDeclineMode,
+		DeclineMessage:  strings.Join(parameters[2:], " "),
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("You are out of office from %s to %s.",
+		event.Start.Time().In(loc).Format(eventStartFormat), event.End.Time().In(loc).Format(eventStartFormat)), nil
+}
+
+// parseAutoDeclineMode returns how the invitations received during a time block are declined from the --decline
+// flag. Only the new invitations are declined by default.
+func parseAutoDeclineMode(flags map[string]string) (string, error) {
+	value, ok := flags["decline"]
+	if !ok {
+		return gcal.AutoDeclineOnlyNewConflicting, nil
+	}
+
+	switch value {
+	case "all":
+		return gcal.AutoDeclineAllConflicting, nil
+	case "new":
+		return gcal.AutoDeclineOnlyNewConflicting, nil
+	case "none":
+		return gcal.AutoDeclineNone, nil
+	}
+	return "", errors.Errorf("invalid decline %q, expected one of all, new or none", value)
+}
+
+// parseChatStatus returns the Google Chat status of the user during focus time from the --status flag, or an empty
+// status to leave the Google default
+func parseChatStatus(flags map[string]string) (string, error) {
+	value, ok := flags["status"]
+	if !ok {
+		return "", nil
+	}
+
+	switch value {
+	case "dnd":
+		return gcal.ChatStatusDoNotDisturb, nil
+	case "available":
+		return gcal.ChatStatusAvailable, nil
+	}
+	return "", errors.Errorf("invalid status %q, expected dnd or available", value)
+}
+
+func (p *Plugin) executeAutoResponderCommand(args *model.CommandArgs, parameters ...string) (string, error) {
+	if len(parameters) == 0 {
+		return autoResponderCommandHelp, nil
+	}
+
+	switch parameters[0] {
+	case "on":
+		err := gcal.SetOutOfOfficeAutoResponder(args.UserId, true)
+		if err != nil {
+			return "", err
+		}
+		return "Your auto-responder will be turned on during your out of office events.", nil
+
+	case "off":
+		err := gcal.SetOutOfOfficeAutoResponder(args.UserId, false)
+		if err != nil {
+			return "", err
+		}
+		return "Your auto-responder will no longer be turned on during your out of office events.", nil
+
+	case "message":
+		message := strings.Join(parameters[1:], " ")
+		err := gcal.SetOutOfOfficeAutoResponderTemplate(args.UserId, message)
+		if err != nil {
+			return "", err
+		}
+		if message == "" {
+			return "Your auto-responder will use the default reply during out of office events without a decline message.", nil
+		}
+		return "Your auto-responder will use this reply during out of office events without a decline message.", nil
+	}
+
+	return autoResponderCommandHelp, nil
+}
+
+// getUserLocation returns the time zone of a user, UTC if they have none
+func (p *Plugin) getUserLocation(mattermostUserID string) (string, *time.Location, error) {
+	user, appErr := p.API.GetUser(mattermostUserID)
+	if appErr != nil {
+		return "", nil, errors.Wrap(appErr, "failed to get user")
+	}
+	timeZone := user.GetPreferredTimezone()
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return "UTC", time.UTC, nil
+	}
+	return timeZone, loc, nil
+}
+
+// parseTimeBlock parses the start and end of a time block in the time zone of the user. An end day includes the
+// whole day.
+func parseTimeBlock(timeZone string, loc *time.Location, startValue, endValue string) (*remote.DateTime, *remote.DateTime, error) {
+	start, err := time.ParseInLocation(commandEventTimeFormat, startValue, loc)
+	if err != nil {
+		start, err = time.ParseInLocation(time.DateOnly, startValue, loc)
+		if err != nil {
+			return nil, nil, errors.Errorf("invalid start %q, expected a time like %s or a day like %s", startValue, commandEventTimeFormat, time.DateOnly)
+		}
+	}
+
+	end, err := time.ParseInLocation(commandEventTimeFormat, endValue, loc)
+	if err != nil {
+		end, err = time.ParseInLocation(time.DateOnly, endValue, loc)
+		if err != nil {
+			return nil, nil, errors.Errorf("invalid end %q, expected a time like %s or a day like %s", endValue, commandEventTimeFormat, time.DateOnly)
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+
+	if !end.After(start) {
+		return nil, nil, errors.New("the end must be after the start")
+	}
+	return remote.NewDateTime(start, timeZone), remote.NewDateTime(end, timeZone), nil
+}
+
+func (p *Plugin) executeRemindersCommand(args *model.CommandArgs, parameters ...string) (string, error) {
+	if len(parameters) != 1 {
+		return remindersCommandHelp, nil
+	}
+
+	switch parameters[0] {
+	case "follow":
+		err := gcal.SetFollowGoogleReminders(args.UserId, true)
+		if err != nil {
+			return "", err
+		}
+		return "You'll be reminded of your events at the times set on them in Google Calendar.", nil
+
+	case "default":
+		err := gcal.SetFollowGoogleReminders(args.UserId, false)
+		if err != nil {
+			return "", err
+		}
+		return "You'll be reminded of your events at the default time.", nil
+	}
+
+	return remindersCommandHelp, nil
+}
+
+func (p *Plugin) executeWorkingLocationCommand(args *model.CommandArgs, parameters ...string) (string, error) {
+	if len(parameters) != 1 {
+		return workingLocationCommandHelp, nil
+	}
+
+	switch parameters[0] {
+	case "on":
+		err := gcal.SetWorkingLocationStatusSync(args.UserId, true)
+		if err != nil {
+			return "", err
+		}
+		return "Your custom status will show the working location you set in Google Calendar.", nil
+
+	case "off":
+		err := gcal.SetWorkingLocationStatusSync(args.UserId, false)
+		if err != nil {
+			return "", err
+		}
+		return "Your custom status will no longer change with your working location.", nil
+	}
+
+	return workingLocationCommandHelp, nil
+}
+
+func (p *Plugin) executeChannelCalendarCommand(args *model.CommandArgs, parameters ...string) (string, error) {
+	if len(parameters) == 0 {
+		return channelCalendarCommandHelp, nil
+	}
+	if !p.API.HasPermissionToChannel(args.UserId, args.ChannelId, model.PermissionManageChannelRoles) {
+		return "", errors.New("only channel admins can manage the calendar of the channel")
+	}
+
+	switch parameters[0] {
+	case "create":
+		role, err := parseChannelCalendarRole(parameters[1:])
+		if err != nil {
+			return "", err
+		}
+
+		channel, appErr := p.API.GetChannel(args.ChannelId)
+		if appErr != nil {
+			return "", errors.Wrap(appErr, "failed to get channel")
+		}
+		if channel.TeamId == "" {
+			return "", errors.New("direct and group messages can't have a calendar")
+		}
+		channelURL, err := p.getChannelURL(args, channel)
+		if err != nil {
+			return "", err
+		}
+
+		c, err := p.makeGcalClient(args.UserId)
+		if err != nil {
+			return "", err
+		}
+		cal, err := c.CreateChannelCalendar(channel.Id, channel.DisplayName, channelURL, role)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("The calendar **%s** was created for this channel. It is shared with the channel members who connected their Google account, as %ss.", cal.Name, role), nil
+
+	case "link":
+		if len(parameters) < 2 {
+			return channelCalendarCommandHelp, nil
+		}
+		role, err := parseChannelCalendarRole(parameters[2:])
+		if err != nil {
+			return "", err
+		}
+
+		c, err := p.makeGcalClient(args.UserId)
+		if err != nil {
+			return "", err
+		}
+		err = c.LinkChannelCalendar(args.ChannelId, parameters[1], role)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("The calendar is linked to this channel. It is shared with the channel members who connected their Google account, as %ss.", role), nil
+
+	case "unlink":
+		c, err := p.makeGcalClient(args.UserId)
+		if err != nil {
+			return "", err
+		}
+		err = c.UnlinkChannelCalendar(args.ChannelId)
+		if err != nil {
+			return "", err
+		}
+		return "The calendar is no longer shared with the channel members.", nil
+	}
+
+	return channelCalendarCommandHelp, nil
+}
+
+// getChannelURL returns the link to a channel. Direct and group messages are linked within the team the command
+// was run in.
+func (p *Plugin) getChannelURL(args *model.CommandArgs, channel *model.Channel) (string, error) {
+	teamID := channel.TeamId
+	if teamID == "" {
+		teamID = args.TeamId
+	}
+	team, appErr := p.API.GetTeam(teamID)
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get team")
+	}
+	return fmt.Sprintf("%s/%s/channels/%s", strings.TrimSuffix(args.SiteURL, "/"), team.Name, channel.Name), nil
+}
+
+// parseChannelCalendarRole returns the role given to the channel members on the channel calendar, reader by default
+func parseChannelCalendarRole(parameters []string) (string, error) {
+	if len(parameters) == 0 {
+		return gcal.ACLRoleReader, nil
+	}
+	switch parameters[0] {
+	case gcal.ACLRoleReader, gcal.ACLRoleWriter:
+		return parameters[0], nil
+	}
+	return "", errors.Errorf("invalid role %q, expected reader or writer", parameters[0])
+}
+
+// executeSubscriptionsCommand lists the event notification subscriptions of all the users, so system admins can
+// tell who doesn't get notified of their event changes
+func (p *Plugin) executeSubscriptionsCommand(args *model.CommandArgs, _ ...string) (string, error) {
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return "", errors.New("only system admins can list the subscriptions")
+	}
+
+	statuses, err := gcal.ListSubscriptionStatuses()
+	if err != nil {
+		return "", err
+	}
+	if len(statuses) == 0 {
+		return "No user is subscribed to event notifications.", nil
+	}
+
+	text := subscriptionsCommandHeader
+	for _, status := range statuses {
+		user := status.MattermostUserID
+		if u, appErr := p.API.GetUser(status.MattermostUserID); appErr == nil {
+			user = "@" + u.Username
+		}
+
+		lastNotification := "never"
+		if !status.LastNotificationAt.IsZero() {
+			lastNotification = status.LastNotificationAt.UTC().Format(subscriptionTimeFormat)
+		}
+
+		text += fmt.Sprintf("| %s | %s | %s | %s | %s |\n", user, status.CalendarID, status.Health,
+			status.ExpiresAt.UTC().Format(subscriptionTimeFormat), lastNotification)
+	}
+	return text, nil
+}
+
+// getDialInText returns how to join the conference of an event by phone, or an empty string if it can't be
+// joined by phone
+func (p *Plugin) getDialInText(c gcalClient, eventID string) string {
+	conference, err := c.GetEventConference(eventID)
+	if err != nil {
+		p.API.LogWarn("Failed to get the conference of the event", "event_id", eventID, "err", err.Error())
+		return ""
+	}
+	if conference == nil || conference.DialIn() == nil {
+		return ""
+	}
+
+	dialIn := conference.DialIn()
+	number := dialIn.Label
+	if number == "" {
+		number = strings.TrimPrefix(dialIn.URI, "tel:")
+	}
+	if dialIn.Pin != "" {
+		return fmt.Sprintf(" Dial in at %s with PIN %s.", number, dialIn.Pin)
+	}
+	return fmt.Sprintf(" Dial in at %s.", number)
+}
+
+// getEventLocation returns the time zone of an event, or the time zone of the user if the event has none
+func (p *Plugin) getEventLocation(mattermostUserID string, event *gcal.Event) (*time.Location, error) {
+	if loc, err := time.LoadLocation(event.TimeZone); event.TimeZone != "" && err == nil {
+		return loc, nil
+	}
+	_, loc, err := p.getUserLocation(mattermostUserID)
+	return loc, err
+}
+
+// parseEventStart parses a new start for an event, either as an offset from its current start or as a time in the
+// given time zone
+func parseEventStart(current time.Time, value string, loc *time.Location) (time.Time, error) {
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		offset, err := time.ParseDuration(value)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "invalid offset")
+		}
+		return current.Add(offset), nil
+	}
+
+	start, err := time.ParseInLocation(commandEventTimeFormat, value, loc)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid start %q, expected a time like %s", value, commandEventTimeFormat)
+	}
+	return start, nil
+}
@@ -0,0 +1,722 @@
+// Copyright (c) 2019-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	mattermostplugin "github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/plugin"
+	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/remote"
+
+	"github.com/mattermost/mattermost-plugin-google-calendar/gcal"
+)
+
+// fakeGcalClient records the calls of the commands. Calling a method it doesn't override panics.
+type fakeGcalClient struct {
+	gcalClient
+
+	channelCalendar []string
+	unlinkedChannel string
+	createdEvent    *remote.Event
+	createdOptions  *gcal.EventOptions
+	event           *gcal.Event
+	rescheduledTo   time.Time
+	focusTime       *gcal.FocusTime
+	outOfOffice     *gcal.OutOfOffice
+	cancelled       []string
+}
+
+// newTimeBlockResult returns the time block event as converted from Google, in UTC
+func newTimeBlockResult(subject string, start, end *remote.DateTime) *remote.Event {
+	return &remote.Event{
+		Subject: subject,
+		Start:   remote.NewDateTime(start.Time().UTC(), "UTC"),
+		End:     remote.NewDateTime(end.Time().UTC(), "UTC"),
+	}
+}
+
+func (c *fakeGcalClient) CreateFocusTimeEvent(subject string, start, end *remote.DateTime, focusTime *gcal.FocusTime) (*remote.Event, error) {
+	c.focusTime = focusTime
+	return newTimeBlockResult(subject, start, end), nil
+}
+
+func (c *fakeGcalClient) CreateOutOfOfficeEvent(subject string, start, end *remote.DateTime, outOfOffice *gcal.OutOfOffice) (*remote.Event, error) {
+	c.outOfOffice = outOfOffice
+	return newTimeBlockResult(subject, start, end), nil
+}
+
+func (c *fakeGcalClient) GetEventDetails(eventID string) (*gcal.Event, error) {
+	return c.event, nil
+}
+
+func (c *fakeGcalClient) RescheduleEvent(eventID string, start time.Time, notifyAttendees bool) (*remote.Event, error) {
+	c.rescheduledTo = start
+	return &remote.Event{
+		Subject: c.event.Subject,
+		Start:   remote.NewDateTime(start.UTC(), "UTC"),
+	}, nil
+}
+
+func (c *fakeGcalClient) CreateEventWithOptions(in *remote.Event, opts *gcal.EventOptions) (*remote.Event, error) {
+	c.createdEvent = in
+	c.createdOptions = opts
+
+	result := *in
+	if opts.AddGoogleMeet {
+		result.Conference = &remote.Conference{URL: "https://meet.google.com/abc-defg-hij", Application: "Google Meet"}
+	}
+	return &result, nil
+}
+
+func (c *fakeGcalClient) GetEventConference(eventID string) (*gcal.Conference, error) {
+	return &gcal.Conference{
+		Application: "Google Meet",
+		EntryPoints: []gcal.ConferenceEntryPoint{
+			{Type: gcal.EntryPointTypeVideo, URI: "https://meet.google.com/abc-defg-hij"},
+			{Type: gcal.EntryPointTypePhone, Label: "+1 555-0100", URI: "tel:+1-555-0100", Pin: "123456789"},
+		},
+	}, nil
+}
+
+func (c *fakeGcalClient) CancelEvent(eventID string, notifyAttendees, notifyChannel bool) error {
+	c.cancelled = []string{eventID, gcal.RecurrenceScopeThis, strconv.FormatBool(notifyChannel)}
+	return nil
+}
+
+func (c *fakeGcalClient) CancelRecurringEvent(eventID, scope string, notifyAttendees, notifyChannel bool) error {
+	c.cancelled = []string{eventID, scope, strconv.FormatBool(notifyChannel)}
+	return nil
+}
+
+func (c *fakeGcalClient) CreateChannelCalendar(channelID, channelDisplayName, channelURL, role string) (*remote.Calendar, error) {
+	c.channelCalendar = []string{channelID, channelDisplayName, channelURL, role}
+	return &remote.Calendar{ID: "calendar_id", Name: channelDisplayName}, nil
+}
+
+func (c *fakeGcalClient) LinkChannelCalendar(channelID, calendarID, role string) error {
+	c.channelCalendar = []string{channelID, calendarID, role}
+	return nil
+}
+
+func (c *fakeGcalClient) UnlinkChannelCalendar(channelID string) error {
+	c.unlinkedChannel = channelID
+	return nil
+}
+
+func newTestPlugin(api *plugintest.API, c gcalClient) *Plugin {
+	p := &Plugin{Plugin: &plugin.Plugin{}}
+	p.SetAPI(api)
+	p.makeGcalClient = func(string) (gcalClient, error) {
+		return c, nil
+	}
+	return p
+}
+
+// newTestUser returns a user in the Europe/Berlin time zone
+func newTestUser() *model.User {
+	return &model.User{
+		Id: "user_id",
+		Timezone: map[string]string{
+			"useAutomaticTimezone": "false",
+			"manualTimezone":       "Europe/Berlin",
+		},
+	}
+}
+
+func executeTestCommand(t *testing.T, p *Plugin, command string) string {
+	resp, appErr := p.ExecuteCommand(&mattermostplugin.Context{}, &model.CommandArgs{
+		Command:   command,
+		UserId:    "user_id",
+		ChannelId: "channel_id",
+		SiteURL:   "https://mattermost.example.com/",
+	})
+	require.Nil(t, appErr)
+	require.Equal(t, model.CommandResponseTypeEphemeral, resp.ResponseType)
+	return resp.Text
+}
+
+func TestChannelCalendarCreateCommand(t *testing.T) {
+	channel := &model.Channel{Id: "channel_id", TeamId: "team_id", Name: "releases", DisplayName: "Releases"}
+
+	for name, tc := range map[string]struct {
+		command          string
+		channelAdmin     bool
+		channel          *model.Channel
+		expectedText     string
+		expectedCalendar []string
+	}{
+		"created for readers": {
+			command:          "/gcal channel-calendar create",
+			channelAdmin:     true,
+			channel:          channel,
+			expectedText:     "The calendar **Releases** was created for this channel. It is shared with the channel members who connected their Google account, as readers.",
+			expectedCalendar: []string{"channel_id", "Releases", "https://mattermost.example.com/team/channels/releases", "reader"},
+		},
+		"created for writers": {
+			command:          "/gcal channel-calendar create writer",
+			channelAdmin:     true,
+			channel:          channel,
+			expectedText:     "The calendar **Releases** was created for this channel. It is shared with the channel members who connected their Google account, as writers.",
+			expectedCalendar: []string{"channel_id", "Releases", "https://mattermost.example.com/team/channels/releases", "writer"},
+		},
+		"not a channel admin": {
+			command:      "/gcal channel-calendar create",
+			channel:      channel,
+			expectedText: "Command /gcal channel-calendar failed: only channel admins can manage the calendar of the channel",
+		},
+		"invalid role": {
+			command:      "/gcal channel-calendar create owner",
+			channelAdmin: true,
+			channel:      channel,
+			expectedText: "Command /gcal channel-calendar failed: invalid role \"owner\", expected reader or writer",
+		},
+		"direct message": {
+			command:      "/gcal channel-calendar create",
+			channelAdmin: true,
+			channel:      &model.Channel{Id: "channel_id", Type: model.ChannelTypeDirect},
+			expectedText: "Command /gcal channel-calendar failed: direct and group messages can't have a calendar",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("HasPermissionToChannel", "user_id", "channel_id", model.PermissionManageChannelRoles).Return(tc.channelAdmin)
+			api.On("GetChannel", "channel_id").Return(tc.channel, nil)
+			api.On("GetTeam", "team_id").Return(&model.Team{Id: "team_id", Name: "team"}, nil)
+			c := &fakeGcalClient{}
+
+			text := executeTestCommand(t, newTestPlugin(api, c), tc.command)
+			require.Equal(t, tc.expectedText, text)
+			require.Equal(t, tc.expectedCalendar, c.channelCalendar)
+		})
+	}
+}
+
+func TestChannelCalendarLinkCommand(t *testing.T) {
+	for name, tc := range map[string]struct {
+		command          string
+		channelAdmin     bool
+		expectedText     string
+		expectedCalendar []string
+		expectedUnlinked string
+	}{
+		"linked for readers": {
+			command:          "/gcal channel-calendar link team@group.calendar.google.com",
+			channelAdmin:     true,
+			expectedText:     "The calendar is linked to this channel. It is shared with the channel members who connected their Google account, as readers.",
+			expectedCalendar: []string{"channel_id", "team@group.calendar.google.com", "reader"},
+		},
+		"linked for writers": {
+			command:          "/gcal channel-calendar link team@group.calendar.google.com writer",
+			channelAdmin:     true,
+			expectedText:     "The calendar is linked to this channel. It is shared with the channel members who connected their Google account, as writers.",
+			expectedCalendar: []string{"channel_id", "team@group.calendar.google.com", "writer"},
+		},
+		"missing calendar": {
+			command:      "/gcal channel-calendar link",
+			channelAdmin: true,
+			expectedText: channelCalendarCommandHelp,
+		},
+		"unlinked": {
+			command:          "/gcal channel-calendar unlink",
+			channelAdmin:     true,
+			expectedText:     "The calendar is no longer shared with the channel members.",
+			expectedUnlinked: "channel_id",
+		},
+		"not a channel admin": {
+			command:      "/gcal channel-calendar unlink",
+			expectedText: "Command /gcal channel-calendar failed: only channel admins can manage the calendar of the channel",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("HasPermissionToChannel", "user_id", "channel_id", model.PermissionManageChannelRoles).Return(tc.channelAdmin)
+			c := &fakeGcalClient{}
+
+			text := executeTestCommand(t, newTestPlugin(api, c), tc.command)
+			require.Equal(t, tc.expectedText, text)
+			require.Equal(t, tc.expectedCalendar, c.channelCalendar)
+			require.Equal(t, tc.expectedUnlinked, c.unlinkedChannel)
+		})
+	}
+}
+
+func TestEventCreateCommand(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		command         string
+		expectedText    string
+		expectedEvent   *remote.Event
+		expectedOptions *gcal.EventOptions
+	}{
+		"single event": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Release review",
+			expectedText: "**Release review** was added to your calendar on Thursday, May 2 at 3:00 PM CEST.",
+			expectedEvent: &remote.Event{
+				Subject: "Release review",
+				Start:   &remote.DateTime{DateTime: "2024-05-02T15:00:00", TimeZone: "Europe/Berlin"},
+				End:     &remote.DateTime{DateTime: "2024-05-02T16:00:00", TimeZone: "Europe/Berlin"},
+			},
+			expectedOptions: &gcal.EventOptions{},
+		},
+		"repeated until a day": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Release review --repeat=weekly --until=2024-06-27",
+			expectedText: "**Release review** was added to your calendar on Thursday, May 2 at 3:00 PM CEST. It repeats weekly.",
+			expectedEvent: &remote.Event{
+				Subject: "Release review",
+				Start:   &remote.DateTime{DateTime: "2024-05-02T15:00:00", TimeZone: "Europe/Berlin"},
+				End:     &remote.DateTime{DateTime: "2024-05-02T16:00:00", TimeZone: "Europe/Berlin"},
+			},
+			expectedOptions: &gcal.EventOptions{Recurrence: &gcal.Recurrence{
+				Frequency: gcal.RecurrenceWeekly,
+				Until:     time.Date(2024, time.June, 27, 23, 59, 59, 0, berlin),
+			}},
+		},
+		"repeated a number of times": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Standup --repeat=daily --count=5",
+			expectedText: "**Standup** was added to your calendar on Thursday, May 2 at 3:00 PM CEST. It repeats daily.",
+			expectedEvent: &remote.Event{
+				Subject: "Standup",
+				Start:   &remote.DateTime{DateTime: "2024-05-02T15:00:00", TimeZone: "Europe/Berlin"},
+				End:     &remote.DateTime{DateTime: "2024-05-02T16:00:00", TimeZone: "Europe/Berlin"},
+			},
+			expectedOptions: &gcal.EventOptions{Recurrence: &gcal.Recurrence{
+				Frequency: gcal.RecurrenceDaily,
+				Count:     5,
+			}},
+		},
+		"on another calendar": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Release review --calendar=team@group.calendar.google.com",
+			expectedText: "**Release review** was added to your calendar on Thursday, May 2 at 3:00 PM CEST.",
+			expectedEvent: &remote.Event{
+				Subject: "Release review",
+				Start:   &remote.DateTime{DateTime: "2024-05-02T15:00:00", TimeZone: "Europe/Berlin"},
+				End:     &remote.DateTime{DateTime: "2024-05-02T16:00:00", TimeZone: "Europe/Berlin"},
+			},
+			expectedOptions: &gcal.EventOptions{CalendarID: "team@group.calendar.google.com"},
+		},
+		"with google meet": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Release review --meet",
+			expectedText: "**Release review** was added to your calendar on Thursday, May 2 at 3:00 PM CEST. Join with Google Meet: https://meet.google.com/abc-defg-hij Dial in at +1 555-0100 with PIN 123456789.",
+			expectedEvent: &remote.Event{
+				Subject: "Release review",
+				Start:   &remote.DateTime{DateTime: "2024-05-02T15:00:00", TimeZone: "Europe/Berlin"},
+				End:     &remote.DateTime{DateTime: "2024-05-02T16:00:00", TimeZone: "Europe/Berlin"},
+			},
+			expectedOptions: &gcal.EventOptions{AddGoogleMeet: true},
+		},
+		"with a mattermost call": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Release review --call",
+			expectedText: "**Release review** was added to your calendar on Thursday, May 2 at 3:00 PM CEST. The call is held in this channel.",
+			expectedEvent: &remote.Event{
+				Subject: "Release review",
+				Start:   &remote.DateTime{DateTime: "2024-05-02T15:00:00", TimeZone: "Europe/Berlin"},
+				End:     &remote.DateTime{DateTime: "2024-05-02T16:00:00", TimeZone: "Europe/Berlin"},
+			},
+			expectedOptions: &gcal.EventOptions{MattermostCallURL: "https://mattermost.example.com/team/channels/releases"},
+		},
+		"with reminders": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Release review --reminders=10m,1h30m",
+			expectedText: "**Release review** was added to your calendar on Thursday, May 2 at 3:00 PM CEST.",
+			expectedEvent: &remote.Event{
+				Subject: "Release review",
+				Start:   &remote.DateTime{DateTime: "2024-05-02T15:00:00", TimeZone: "Europe/Berlin"},
+				End:     &remote.DateTime{DateTime: "2024-05-02T16:00:00", TimeZone: "Europe/Berlin"},
+			},
+			expectedOptions: &gcal.EventOptions{ReminderMinutes: []int{10, 90}},
+		},
+		"without reminders": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Release review --reminders=none",
+			expectedText: "**Release review** was added to your calendar on Thursday, May 2 at 3:00 PM CEST.",
+			expectedEvent: &remote.Event{
+				Subject: "Release review",
+				Start:   &remote.DateTime{DateTime: "2024-05-02T15:00:00", TimeZone: "Europe/Berlin"},
+				End:     &remote.DateTime{DateTime: "2024-05-02T16:00:00", TimeZone: "Europe/Berlin"},
+			},
+			expectedOptions: &gcal.EventOptions{ReminderMinutes: []int{}},
+		},
+		"invalid reminder": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Release review --reminders=10m,soon",
+			expectedText: "Command /gcal event failed: invalid reminder \"soon\", expected an offset in minutes or hours like 10m or 1h",
+		},
+		"missing title": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00",
+			expectedText: eventCommandHelp,
+		},
+		"invalid frequency": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Standup --repeat=hourly",
+			expectedText: "Command /gcal event failed: invalid repeat \"hourly\", expected one of daily, weekly, monthly or yearly",
+		},
+		"count and until": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Standup --repeat=daily --count=5 --until=2024-06-27",
+			expectedText: "Command /gcal event failed: recurrence count and until can't be set at the same time",
+		},
+		"count without repeat": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Standup --count=5",
+			expectedText: "Command /gcal event failed: --count is only valid with --repeat",
+		},
+		"unknown flag": {
+			command:      "/gcal event create 2024-05-02T15:00 2024-05-02T16:00 Standup --private",
+			expectedText: "Command /gcal event failed: unknown flag \"--private\"",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("GetUser", "user_id").Return(newTestUser(), nil)
+			api.On("GetChannel", "channel_id").Return(&model.Channel{Id: "channel_id", TeamId: "team_id", Name: "releases"}, nil)
+			api.On("GetTeam", "team_id").Return(&model.Team{Id: "team_id", Name: "team"}, nil)
+			c := &fakeGcalClient{}
+
+			text := executeTestCommand(t, newTestPlugin(api, c), tc.command)
+			require.Equal(t, tc.expectedText, text)
+			require.Equal(t, tc.expectedEvent, c.createdEvent)
+			require.Equal(t, tc.expectedOptions, c.createdOptions)
+		})
+	}
+}
+
+func TestWorkingLocationCommand(t *testing.T) {
+	defer gcal.SetPluginAPI(nil)
+
+	for name, tc := range map[string]struct {
+		command      string
+		expectedText string
+		// expectedCall is the KV call that turns the sync on or off, if any
+		expectedCall string
+	}{
+		"on": {
+			command:      "/gcal working-location on",
+			expectedText: "Your custom status will show the working location you set in Google Calendar.",
+			expectedCall: "KVDelete",
+		},
+		"off": {
+			command:      "/gcal working-location off",
+			expectedText: "Your custom status will no longer change with your working location.",
+			expectedCall: "KVSet",
+		},
+		"help": {
+			command:      "/gcal working-location",
+			expectedText: workingLocationCommandHelp,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("KVSet", mock.AnythingOfType("string"), []byte("true")).Return(nil)
+			api.On("KVDelete", mock.AnythingOfType("string")).Return(nil)
+			gcal.SetPluginAPI(api)
+
+			text := executeTestCommand(t, newTestPlugin(api, &fakeGcalClient{}), tc.command)
+			require.Equal(t, tc.expectedText, text)
+
+			switch tc.expectedCall {
+			case "KVDelete":
+				api.AssertCalled(t, "KVDelete", "gcal_working_location_status_disabled_user_id")
+			case "KVSet":
+				api.AssertCalled(t, "KVSet", "gcal_working_location_status_disabled_user_id", []byte("true"))
+			default:
+				api.AssertNotCalled(t, "KVSet", mock.Anything, mock.Anything)
+				api.AssertNotCalled(t, "KVDelete", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestAutoResponderCommand(t *testing.T) {
+	defer gcal.SetPluginAPI(nil)
+
+	for name, tc := range map[string]struct {
+		command      string
+		expectedText string
+		// expectedKey and expectedValue are the KV value set by the command, a nil value being deleted
+		expectedKey   string
+		expectedValue []byte
+	}{
+		"on": {
+			command:      "/gcal ooo autoresponder on",
+			expectedText: "Your auto-responder will be turned on during your out of office events.",
+			expectedKey:  "gcal_auto_responder_disabled_user_id",
+		},
+		"off": {
+			command:       "/gcal ooo autoresponder off",
+			expectedText:  "Your auto-responder will no longer be turned on during your out of office events.",
+			expectedKey:   "gcal_auto_responder_disabled_user_id",
+			expectedValue: []byte("true"),
+		},
+		"message": {
+			command:       "/gcal ooo autoresponder message Back on {end}, ask @alice meanwhile.",
+			expectedText:  "Your auto-responder will use this reply during out of office events without a decline message.",
+			expectedKey:   "gcal_auto_responder_template_user_id",
+			expectedValue: []byte(`"Back on {end}, ask @alice meanwhile."`),
+		},
+		"default message": {
+			command:      "/gcal ooo autoresponder message",
+			expectedText: "Your auto-responder will use the default reply during out of office events without a decline message.",
+			expectedKey:  "gcal_auto_responder_template_user_id",
+		},
+		"help": {
+			command:      "/gcal ooo autoresponder",
+			expectedText: autoResponderCommandHelp,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("KVSet", mock.AnythingOfType("string"), mock.Anything).Return(nil)
+			api.On("KVDelete", mock.AnythingOfType("string")).Return(nil)
+			gcal.SetPluginAPI(api)
+
+			text := executeTestCommand(t, newTestPlugin(api, &fakeGcalClient{}), tc.command)
+			require.Equal(t, tc.expectedText, text)
+
+			switch {
+			case tc.expectedKey == "":
+				api.AssertNotCalled(t, "KVSet", mock.Anything, mock.Anything)
+				api.AssertNotCalled(t, "KVDelete", mock.Anything)
+			case tc.expectedValue == nil:
+				api.AssertCalled(t, "KVDelete", tc.expectedKey)
+			default:
+				api.AssertCalled(t, "KVSet", tc.expectedKey, tc.expectedValue)
+			}
+		})
+	}
+}
+
+func TestEventRescheduleCommand(t *testing.T) {
+	newEvent := func(timeZone string) *gcal.Event {
+		return &gcal.Event{
+			Event: &remote.Event{
+				ID:      "event_id",
+				Subject: "Release review",
+				Start:   &remote.DateTime{DateTime: "2024-05-02T13:00:00", TimeZone: "UTC"},
+			},
+			TimeZone: timeZone,
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		command       string
+		event         *gcal.Event
+		expectedText  string
+		expectedStart time.Time
+	}{
+		"time in the time zone of the event": {
+			command:       "/gcal event reschedule event_id 2024-05-02T09:30",
+			event:         newEvent("America/New_York"),
+			expectedText:  "The event **Release review** was moved to Thursday, May 2 at 9:30 AM EDT.",
+			expectedStart: time.Date(2024, time.May, 2, 13, 30, 0, 0, time.UTC),
+		},
+		"time in the time zone of the user": {
+			command:       "/gcal event reschedule event_id 2024-05-02T15:30",
+			event:         newEvent(""),
+			expectedText:  "The event **Release review** was moved to Thursday, May 2 at 3:30 PM CEST.",
+			expectedStart: time.Date(2024, time.May, 2, 13, 30, 0, 0, time.UTC),
+		},
+		"offset": {
+			command:       "/gcal event reschedule event_id +1h",
+			event:         newEvent("America/New_York"),
+			expectedText:  "The event **Release review** was moved to Thursday, May 2 at 10:00 AM EDT.",
+			expectedStart: time.Date(2024, time.May, 2, 14, 0, 0, 0, time.UTC),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("GetUser", "user_id").Return(newTestUser(), nil)
+			c := &fakeGcalClient{event: tc.event}
+
+			text := executeTestCommand(t, newTestPlugin(api, c), tc.command)
+			require.Equal(t, tc.expectedText, text)
+			require.True(t, tc.expectedStart.Equal(c.rescheduledTo), c.rescheduledTo)
+		})
+	}
+}
+
+func TestEventCancelCommand(t *testing.T) {
+	for name, tc := range map[string]struct {
+		command           string
+		expectedCancelled []string
+	}{
+		"event": {
+			command:           "/gcal event cancel event_id",
+			expectedCancelled: []string{"event_id", gcal.RecurrenceScopeThis, "false"},
+		},
+		"event with channel notice": {
+			command:           "/gcal event cancel event_id notify-channel",
+			expectedCancelled: []string{"event_id", gcal.RecurrenceScopeThis, "true"},
+		},
+		"series with channel notice": {
+			command:           "/gcal event cancel event_id notify-channel --scope=all",
+			expectedCancelled: []string{"event_id", gcal.RecurrenceScopeAll, "true"},
+		},
+		"following occurrences with channel notice": {
+			command:           "/gcal event cancel --scope=following event_id notify-channel",
+			expectedCancelled: []string{"event_id", gcal.RecurrenceScopeFollowing, "true"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := &fakeGcalClient{event: &gcal.Event{
+				Event:            &remote.Event{ID: "event_id"},
+				RecurringEventID: "series_id",
+			}}
+
+			text := executeTestCommand(t, newTestPlugin(&plugintest.API{}, c), tc.command)
+			require.Contains(t, text, "The event was cancelled.")
+			require.Equal(t, tc.expectedCancelled, c.cancelled)
+		})
+	}
+}
+
+func TestParseEventStart(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	current := time.Date(2024, time.May, 2, 13, 0, 0, 0, time.UTC)
+
+	for name, tc := range map[string]struct {
+		value         string
+		loc           *time.Location
+		expectedStart time.Time
+		expectedError string
+	}{
+		"later offset": {
+			value:         "+30m",
+			loc:           newYork,
+			expectedStart: time.Date(2024, time.May, 2, 13, 30, 0, 0, time.UTC),
+		},
+		"earlier offset": {
+			value:         "-1h",
+			loc:           newYork,
+			expectedStart: time.Date(2024, time.May, 2, 12, 0, 0, 0, time.UTC),
+		},
+		"time in utc": {
+			value:         "2024-05-03T09:00",
+			loc:           time.UTC,
+			expectedStart: time.Date(2024, time.May, 3, 9, 0, 0, 0, time.UTC),
+		},
+		"time in another time zone": {
+			value:         "2024-05-03T09:00",
+			loc:           newYork,
+			expectedStart: time.Date(2024, time.May, 3, 13, 0, 0, 0, time.UTC),
+		},
+		"invalid offset": {
+			value:         "+soon",
+			loc:           newYork,
+			expectedError: "invalid offset",
+		},
+		"invalid time": {
+			value:         "tomorrow",
+			loc:           newYork,
+			expectedError: "invalid start \"tomorrow\", expected a time like 2006-01-02T15:04",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			start, err := parseEventStart(current, tc.value, tc.loc)
+			if tc.expectedError != "" {
+				require.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, tc.expectedStart.Equal(start), start)
+		})
+	}
+}
+
+func TestParseRecurrenceScope(t *testing.T) {
+	for name, tc := range map[string]struct {
+		parameters         []string
+		expectedParameters []string
+		expectedScope      string
+		expectedError      string
+	}{
+		"no scope": {
+			parameters:         []string{"rename", "event_id", "Standup"},
+			expectedParameters: []string{"rename", "event_id", "Standup"},
+			expectedScope:      gcal.RecurrenceScopeThis,
+		},
+		"following": {
+			parameters:         []string{"rename", "--scope=following", "event_id", "Standup"},
+			expectedParameters: []string{"rename", "event_id", "Standup"},
+			expectedScope:      gcal.RecurrenceScopeFollowing,
+		},
+		"all at the end": {
+			parameters:         []string{"cancel", "event_id", "--scope=all"},
+			expectedParameters: []string{"cancel", "event_id"},
+			expectedScope:      gcal.RecurrenceScopeAll,
+		},
+		"invalid scope": {
+			parameters:    []string{"cancel", "event_id", "--scope=some"},
+			expectedError: "invalid scope \"some\", expected one of this, following or all",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			parameters, scope, err := parseRecurrenceScope(tc.parameters)
+			if tc.expectedError != "" {
+				require.EqualError(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedParameters, parameters)
+			require.Equal(t, tc.expectedScope, scope)
+		})
+	}
+}
+
+func TestTimeBlockCommands(t *testing.T) {
+	for name, tc := range map[string]struct {
+		command             string
+		expectedText        string
+		expectedFocusTime   *gcal.FocusTime
+		expectedOutOfOffice *gcal.OutOfOffice
+	}{
+		"focus": {
+			command:           "/gcal focus 2024-05-02T09:00 2024-05-02T12:00 Writing",
+			expectedText:      "**Writing** was added to your calendar from Thursday, May 2 at 9:00 AM CEST to Thursday, May 2 at 12:00 PM CEST.",
+			expectedFocusTime: &gcal.FocusTime{AutoDeclineMode: gcal.AutoDeclineOnlyNewConflicting},
+		},
+		"focus declining all and not disturbed": {
+			command:           "/gcal focus 2024-05-02T09:00 2024-05-02T12:00 Writing --decline=all --status=dnd",
+			expectedText:      "**Writing** was added to your calendar from Thursday, May 2 at 9:00 AM CEST to Thursday, May 2 at 12:00 PM CEST.",
+			expectedFocusTime: &gcal.FocusTime{AutoDeclineMode: gcal.AutoDeclineAllConflicting, ChatStatus: gcal.ChatStatusDoNotDisturb},
+		},
+		"focus with an invalid status": {
+			command:      "/gcal focus 2024-05-02T09:00 2024-05-02T12:00 --status=away",
+			expectedText: "Command /gcal focus failed: invalid status \"away\", expected dnd or available",
+		},
+		"out of office": {
+			command:             "/gcal ooo 2024-05-02 2024-05-03 At the offsite",
+			expectedText:        "You are out of office from Thursday, May 2 at 12:00 AM CEST to Saturday, May 4 at 12:00 AM CEST.",
+			expectedOutOfOffice: &gcal.OutOfOffice{AutoDeclineMode: gcal.AutoDeclineOnlyNewConflicting, DeclineMessage: "At the offsite"},
+		},
+		"out of office declining nothing": {
+			command:             "/gcal ooo 2024-05-02 2024-05-03 --decline=none",
+			expectedText:        "You are out of office from Thursday, May 2 at 12:00 AM CEST to Saturday, May 4 at 12:00 AM CEST.",
+			expectedOutOfOffice: &gcal.OutOfOffice{AutoDeclineMode: gcal.AutoDeclineNone},
+		},
+		"out of office with an invalid decline": {
+			command:      "/gcal ooo 2024-05-02 2024-05-03 --decline=some",
+			expectedText: "Command /gcal ooo failed: invalid decline \"some\", expected one of all, new or none",
+		},
+		"out of office has no status": {
+			command:      "/gcal ooo 2024-05-02 2024-05-03 --status=dnd",
+			expectedText: "Command /gcal ooo failed: unknown flag \"--status\"",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			api.On("GetUser", "user_id").Return(newTestUser(), nil)
+			c := &fakeGcalClient{}
+
+			text := executeTestCommand(t, newTestPlugin(api, c), tc.command)
+			require.Equal(t, tc.expectedText, text)
+			require.Equal(t, tc.expectedFocusTime, c.focusTime)
+			require.Equal(t, tc.expectedOutOfOffice, c.outOfOffice)
+		})
+	}
+}
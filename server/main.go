@@ -4,7 +4,12 @@
 package main
 
 import (
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
 	mattermostplugin "github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+	"github.com/pkg/errors"
 
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/config"
 	"github.com/mattermost/mattermost-plugin-mscalendar/calendar/engine"
@@ -20,20 +25,108 @@ var (
 	CalendarProvider string
 )
 
+// Plugin wraps the calendar engine plugin to handle the Mattermost events the provider needs
+type Plugin struct {
+	*plugin.Plugin
+
+	// env shares its dependencies with the engine plugin, which sets them up on activation
+	env               engine.Env
+	backgroundSyncJob *cluster.Job
+
+	// makeGcalClient returns the client of a user connected to Google
+	makeGcalClient func(mattermostUserID string) (gcalClient, error)
+}
+
+// configuration holds the settings of the provider the calendar engine doesn't read
+type configuration struct {
+	SubscriptionTTLHours int
+}
+
+func (p *Plugin) OnConfigurationChange() error {
+	err := p.Plugin.OnConfigurationChange()
+	if err != nil {
+		return err
+	}
+
+	conf := &configuration{}
+	err = p.API.LoadPluginConfiguration(conf)
+	if err != nil {
+		return errors.Wrap(err, "failed to load plugin configuration")
+	}
+
+	ttl := gcal.DefaultSubscriptionTTL
+	if conf.SubscriptionTTLHours > 0 {
+		ttl = time.Duration(conf.SubscriptionTTLHours) * time.Hour
+	}
+	if used := gcal.SetSubscriptionTTL(ttl); used != ttl {
+		p.API.LogWarn("The event notifications renewal period is too short for the subscriptions to be renewed in time, using the shortest one that is",
+			"configured_hours", conf.SubscriptionTTLHours, "used_hours", int(used/time.Hour))
+	}
+	return nil
+}
+
+func (p *Plugin) OnActivate() error {
+	err := p.Plugin.OnActivate()
+	if err != nil {
+		return err
+	}
+
+	gcal.SetPluginAPI(p.API)
+	return p.scheduleBackgroundSync()
+}
+
+func (p *Plugin) OnDeactivate() error {
+	if p.backgroundSyncJob != nil {
+		err := p.backgroundSyncJob.Close()
+		if err != nil {
+			p.API.LogWarn("Failed to stop the background sync", "err", err.Error())
+		}
+	}
+
+	return p.Plugin.OnDeactivate()
+}
+
+func (p *Plugin) UserHasJoinedChannel(_ *mattermostplugin.Context, channelMember *model.ChannelMember, _ *model.User) {
+	p.markChannelCalendarForSync(channelMember.ChannelId)
+}
+
+func (p *Plugin) UserHasLeftChannel(_ *mattermostplugin.Context, channelMember *model.ChannelMember, _ *model.User) {
+	p.markChannelCalendarForSync(channelMember.ChannelId)
+}
+
+// ChannelWillBeArchived flags the channel calendar, so it is deleted along with the channel. Servers without this hook
+// delete it on the next periodic sync.
+func (p *Plugin) ChannelWillBeArchived(_ *mattermostplugin.Context, channel *model.Channel) string {
+	p.markChannelCalendarForSync(channel.Id)
+	return ""
+}
+
+func (p *Plugin) markChannelCalendarForSync(channelID string) {
+	err := gcal.MarkChannelCalendarForSync(channelID)
+	if err != nil {
+		p.API.LogWarn("Failed to flag the channel calendar for sync", "channel_id", channelID, "err", err.Error())
+	}
+}
+
 func main() {
 	config.Provider = gcal.GetGcalProviderConfig()
 
-	mattermostplugin.ClientMain(
-		plugin.NewWithEnv(
-			engine.Env{
-				Config: &config.Config{
-					PluginID:       manifest.Id,
-					PluginVersion:  manifest.Version,
-					BuildHash:      BuildHash,
-					BuildHashShort: BuildHashShort,
-					BuildDate:      BuildDate,
-					Provider:       config.Provider,
-				},
-				Dependencies: &engine.Dependencies{},
-			}))
+	env := engine.Env{
+		Config: &config.Config{
+			PluginID:       manifest.Id,
+			PluginVersion:  manifest.Version,
+			BuildHash:      BuildHash,
+			BuildHashShort: BuildHashShort,
+			BuildDate:      BuildDate,
+			Provider:       config.Provider,
+		},
+		Dependencies: &engine.Dependencies{},
+	}
+
+	p := &Plugin{
+		Plugin: plugin.NewWithEnv(env),
+		env:    env,
+	}
+	p.makeGcalClient = p.makeEngineGcalClient
+	mattermostplugin.ClientMain(p)
 }